@@ -73,6 +73,10 @@ func (t *titleFeature) String() string {
 	return fmt.Sprintf("title*%s", t.word)
 }
 
+func (t *titleFeature) ID() string {
+	return t.String()
+}
+
 func (t *titleFeature) Predict(e ml.Example) float64 {
 	if _, ok := e.(*IssueExample).titleWords[t.word]; ok {
 		return 1.0
@@ -89,6 +93,10 @@ func (f *contentFeature) String() string {
 	return f.word
 }
 
+func (f *contentFeature) ID() string {
+	return fmt.Sprintf("content*%s", f.word)
+}
+
 func (f *contentFeature) Predict(e ml.Example) float64 {
 	if _, ok := e.(*IssueExample).contentWords[f.word]; ok {
 		return 1.0
@@ -229,12 +237,15 @@ func main() {
 
 	// Build a decision tree.
 	// stumper := ml.NewDecisionStumper(features, dev, r)
+	// log.Printf("removed %d duplicate features", stumper.DuplicateFeatures())
 	maxDecisionTreeDepth := 3
 	treeBuilder := ml.NewDecisionTreeBuilder(features, maxDecisionTreeDepth)
 	booster := ml.NewAdaBoost(dev, treeBuilder, r)
 
 	for i := 0; ; i++ {
-		booster.Round(1000)
+		if err := booster.Round(1000); err != nil {
+			log.Fatal(err)
+		}
 		fmt.Printf("%d: dev=%f test=%f a=%f\n", i, booster.Evaluate(dev), booster.Evaluate(test), booster.A[i])
 		debugDumpExampleWeights(booster)
 	}
@@ -1,7 +1,10 @@
 package ml
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 )
@@ -21,7 +24,21 @@ type AdaBoost struct {
 	D       *Distribution
 	H       []Classifier
 	A       []float64
-	rand    *rand.Rand
+	// Eta scales the weight of each new classifier, shrinking its
+	// contribution to the D update and to Predict. It defaults to 1.0.
+	Eta float64
+	// OnRound, if set, is called at the end of each round with the
+	// round's index, its classifier and the error rate on Examples.
+	OnRound func(round int, h Classifier, trainError float64)
+	// TrainErrors records the error rate on Examples after each round.
+	TrainErrors []float64
+	rand        *rand.Rand
+
+	// scores caches Predict on each of Examples, for the first scored
+	// classifiers. Methods which change H, A or Examples reset it;
+	// callers who change those fields directly must call ResetCache.
+	scores []float64
+	scored int
 }
 
 func NewAdaBoost(es []Example, learner Learner, r *rand.Rand) *AdaBoost {
@@ -32,8 +49,50 @@ func NewAdaBoost(es []Example, learner Learner, r *rand.Rand) *AdaBoost {
 		dist,
 		nil,
 		nil,
+		1.0,
+		nil,
+		nil,
 		r,
+		nil,
+		0,
+	}
+}
+
+// NewAdaBoostWeighted is like NewAdaBoost, but starts with each
+// example's weight proportional to weights rather than uniform. It is
+// an error for weights to be negative, all zero, or a different length
+// to es.
+func NewAdaBoostWeighted(es []Example, learner Learner, r *rand.Rand, weights []float64) (*AdaBoost, error) {
+	if len(weights) != len(es) {
+		return nil, fmt.Errorf("%d weights for %d examples", len(weights), len(es))
+	}
+	dist, err := NewDistributionFromCounts(weights)
+	if err != nil {
+		return nil, err
 	}
+	a := NewAdaBoost(es, learner, r)
+	a.D = dist
+	return a, nil
+}
+
+// NewAdaBoostWithClassWeights is like NewAdaBoost, but starts with
+// each example's weight proportional to the weight of its class, so a
+// rare class can be given more influence. Classes missing from
+// classWeights have weight 1.0; with no class weights this is
+// NewAdaBoost. Since the updates in Round are multiplicative the
+// scaling carries through every round.
+func NewAdaBoostWithClassWeights(es []Example, learner Learner, r *rand.Rand, classWeights map[Label]float64) (*AdaBoost, error) {
+	if len(classWeights) == 0 {
+		return NewAdaBoost(es, learner, r), nil
+	}
+	weights := make([]float64, len(es))
+	for i, example := range es {
+		weights[i] = 1.0
+		if w, ok := classWeights[example.Label()]; ok {
+			weights[i] = w
+		}
+	}
+	return NewAdaBoostWeighted(es, learner, r, weights)
 }
 
 func float64OfLabel(label Label) float64 {
@@ -44,22 +103,29 @@ func float64OfLabel(label Label) float64 {
 	}
 }
 
-// Evaluates Classifier c and on examples and returns the error rate, 0.0-1.0.
-func evaluateClassifier(c Classifier, examples []Example) float64 {
-	return evaluateClassifierWeighted(c, examples, UniformDistribution(len(examples)))
-}
-
-func evaluateClassifierWeighted(c Classifier, examples []Example, d *Distribution) float64 {
+// WeightedError evaluates Classifier c on examples and returns the
+// weight, under d, of the examples it misclassifies. Examples c
+// abstains on by predicting 0.0 are not misclassified. Round requires
+// this to be less than 0.5 for a classifier to help the ensemble.
+func WeightedError(c Classifier, examples []Example, d *Distribution) float64 {
 	misclassifications := 0.0
 	for i, example := range examples {
-		if !math.Signbit(c.Predict(example)) != bool(example.Label()) {
+		if float64OfLabel(example.Label())*c.Predict(example) < 0.0 {
 			misclassifications += d.P[i]
 		}
 	}
 	return misclassifications
 }
 
-func (a *AdaBoost) Round(nexamples int) {
+// minRoundError is the smallest error Round weights a classifier by.
+const minRoundError = 1e-10
+
+// Round runs one boosting round: it trains a classifier on nexamples
+// examples drawn from D, weights it by its error and reweights D. It
+// returns an error, leaving the ensemble unchanged, if the classifier
+// is right on none of the weight, for example because it abstains on
+// every example, or if the new weights underflow to zero.
+func (a *AdaBoost) Round(nexamples int) error {
 	// Sample from the examples for this round.
 	cumulative := CumulativeDistributionOfDistribution(a.D)
 	var examples []Example
@@ -69,16 +135,244 @@ func (a *AdaBoost) Round(nexamples int) {
 
 	h := a.Learner.NewClassifier(examples)
 
-	// Calculate the error of this classifier.
-	e_t := evaluateClassifierWeighted(h, a.Examples, a.D)
-	e_t = math.Max(e_t, math.SmallestNonzeroFloat64)
-	a_t := 0.5 * math.Log((1-e_t)/e_t)
+	// Calculate the error of this classifier, and the weight of the
+	// examples it abstains on by predicting 0.0. For classifiers which
+	// never abstain this is discrete AdaBoost; otherwise it is the
+	// abstaining variant from Schapire and Singer's "Improved Boosting
+	// Algorithms Using Confidence-rated Predictions".
+	e_t := WeightedError(h, a.Examples, a.D)
+	predictions := make([]float64, len(a.Examples))
+	w_0 := 0.0
 	for i, example := range a.Examples {
-		a.D.P[i] *= math.Exp(-a_t * float64OfLabel(example.Label()) * h.Predict(example))
+		predictions[i] = h.Predict(example)
+		if predictions[i] == 0.0 {
+			w_0 += a.D.P[i]
+		}
+	}
+	// A perfect classifier would get an infinite weight and zero the
+	// weight of every example, so clamp its error.
+	e_t = math.Max(e_t, minRoundError)
+	if 1-w_0-e_t <= 0.0 {
+		return fmt.Errorf("classifier %v is right on none of the weight: it errs on %g and abstains on %g", h, e_t, w_0)
+	}
+	a_t := a.Eta * 0.5 * math.Log((1-w_0-e_t)/e_t)
+	d := &Distribution{make([]float64, len(a.D.P))}
+	for i, example := range a.Examples {
+		d.P[i] = a.D.P[i] * math.Exp(-a_t*float64OfLabel(example.Label())*predictions[i])
+	}
+	if err := d.Normalize(); err != nil {
+		return err
+	}
+	copy(a.D.P, d.P)
+
+	scores := a.trainScores()
+	var m ConfusionMatrix
+	for i, example := range a.Examples {
+		scores[i] += a_t * predictions[i]
+		m.add(Label(scores[i] > 0.0), example.Label())
 	}
-	a.D.Normalize()
 	a.H = append(a.H, h)
 	a.A = append(a.A, a_t)
+	a.scored = len(a.H)
+	a.TrainErrors = append(a.TrainErrors, m.ErrorRate())
+	if a.OnRound != nil {
+		a.OnRound(len(a.H)-1, h, m.ErrorRate())
+	}
+	return nil
+}
+
+// ResetCache discards the scores on Examples cached during training.
+// Call it after changing H, A or Examples directly rather than through
+// methods such as Truncate, Prepend and AddExamples.
+func (a *AdaBoost) ResetCache() {
+	a.scores = nil
+	a.scored = 0
+}
+
+// trainScores returns Predict on each of Examples, updating the cache
+// if the examples or classifiers have changed since it was built.
+func (a *AdaBoost) trainScores() []float64 {
+	if a.scores == nil || len(a.scores) != len(a.Examples) || a.scored != len(a.H) {
+		a.scores = make([]float64, len(a.Examples))
+		for i, example := range a.Examples {
+			a.scores[i] = a.Predict(example)
+		}
+		a.scored = len(a.H)
+	}
+	return a.scores
+}
+
+// Train runs rounds boosting rounds, each sampling nexamples examples.
+// It stops at the first round which returns an error.
+func (a *AdaBoost) Train(rounds int, nexamples int) error {
+	for i := 0; i < rounds; i++ {
+		if err := a.Round(nexamples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrainContext is like Train, but stops early if ctx is done. It
+// returns the number of rounds run and, if it stopped early,
+// ctx.Err() or the error from Round. The ensemble trained so far
+// remains usable.
+func (a *AdaBoost) TrainContext(ctx context.Context, rounds int, nexamples int) (int, error) {
+	for i := 0; i < rounds; i++ {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := a.Round(nexamples); err != nil {
+			return i, err
+		}
+	}
+	return rounds, nil
+}
+
+// TrainWithCheckpoints is like Train, but after each `every` rounds it
+// saves the ensemble with Save to the writer w returns for the number
+// of rounds in the ensemble. It stops at the first error training or
+// saving. A checkpoint can be loaded with LoadAdaBoost and training
+// continued with Resume and Train.
+func (a *AdaBoost) TrainWithCheckpoints(rounds int, nexamples int, every int, w func(round int) io.Writer) error {
+	for i := 1; i <= rounds; i++ {
+		if err := a.Round(nexamples); err != nil {
+			return err
+		}
+		if every > 0 && i%every == 0 {
+			if err := a.Save(w(len(a.H))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TrainUntilConverged runs boosting rounds, each sampling nexamples
+// examples, until the error rate on val has not improved for patience
+// consecutive rounds or maxRounds rounds have run. The ensemble is
+// then truncated to the best performing prefix, and D restored to the
+// weights after that round. It returns the number of rounds run. If
+// val is empty it simply runs maxRounds rounds. Patience must be
+// positive. If a round returns an error training stops, the ensemble
+// is truncated as above, and the error is returned.
+func (a *AdaBoost) TrainUntilConverged(val []Example, patience int, maxRounds int, nexamples int) (int, error) {
+	if patience <= 0 {
+		return 0, fmt.Errorf("patience must be positive but was %d", patience)
+	}
+	if len(val) == 0 {
+		for rounds := 0; rounds < maxRounds; rounds++ {
+			if err := a.Round(nexamples); err != nil {
+				return rounds, err
+			}
+		}
+		return maxRounds, nil
+	}
+
+	best := len(a.H)
+	bestError := a.ConfusionMatrix(val).ErrorRate()
+	bestD := append([]float64(nil), a.D.P...)
+	rounds := 0
+	var err error
+	for stale := 0; rounds < maxRounds && stale < patience; rounds++ {
+		if err = a.Round(nexamples); err != nil {
+			break
+		}
+		if e := a.ConfusionMatrix(val).ErrorRate(); e < bestError {
+			best = len(a.H)
+			bestError = e
+			bestD = append(bestD[:0], a.D.P...)
+			stale = 0
+		} else {
+			stale++
+		}
+	}
+
+	a.Truncate(best)
+	a.D.P = bestD
+	return rounds, err
+}
+
+// Resume prepares an ensemble, such as one from LoadAdaBoost, for
+// more rounds of training. Save keeps the classifiers, their weights
+// and Eta, so later rounds use the same shrinkage, but the caller
+// must supply the training examples, the learner and the source of
+// randomness again. D is rebuilt as the weights training would have
+// reached, proportional to exp(-y F(x)) where F is Predict; this
+// assumes training started from uniform weights, as from NewAdaBoost.
+func (a *AdaBoost) Resume(es []Example, learner Learner, r *rand.Rand) {
+	a.Examples = es
+	a.Learner = learner
+	a.rand = r
+	a.ResetCache()
+	if len(es) == 0 {
+		a.D = UniformDistribution(0)
+		return
+	}
+	logits := make([]float64, len(es))
+	for i, score := range a.trainScores() {
+		logits[i] = -float64OfLabel(es[i].Label()) * score
+	}
+	a.D = SoftmaxDistribution(logits, 1.0)
+}
+
+// Prepend inserts classifiers, with weights alphas, at the start of
+// the ensemble, for example to warm start from a related model. D is
+// updated as though they had been trained in earlier rounds; since
+// the updates are multiplicative their order does not matter, so
+// later rounds continue exactly as if training had included them. The
+// weights are used as given, not recomputed on Examples. TrainErrors
+// no longer lines up with the ensemble and is cleared. It is an error
+// for there to be a different number of classifiers and weights.
+func (a *AdaBoost) Prepend(classifiers []Classifier, alphas []float64) error {
+	if len(classifiers) != len(alphas) {
+		return fmt.Errorf("%d classifiers but %d weights", len(classifiers), len(alphas))
+	}
+	if a.D != nil && len(a.D.P) > 0 {
+		for i, example := range a.Examples {
+			y := float64OfLabel(example.Label())
+			for t, h := range classifiers {
+				a.D.P[i] *= math.Exp(-alphas[t] * y * h.Predict(example))
+			}
+		}
+		if err := a.D.Normalize(); err != nil {
+			return err
+		}
+	}
+	a.H = append(append([]Classifier(nil), classifiers...), a.H...)
+	a.A = append(append([]float64(nil), alphas...), a.A...)
+	a.TrainErrors = nil
+	a.ResetCache()
+	return nil
+}
+
+// AddExamples adds training examples for later rounds. The new
+// examples start with the weight every example had before the first
+// round, 1/n, and the existing weights are scaled down to make room;
+// they are not reweighted for the rounds which have already run. If
+// the learner has an AddExamples method it is passed the examples too.
+// A model without D, such as one from LoadAdaBoost, starts from no
+// examples, so the new examples are weighted uniformly; use Resume to
+// weight them by the loaded ensemble instead.
+func (a *AdaBoost) AddExamples(es []Example) {
+	if a.D == nil {
+		a.D = UniformDistribution(0)
+	}
+	n := float64(len(a.Examples) + len(es))
+	if len(a.Examples) > 0 {
+		// The factor is in (0, 1], which Scale accepts.
+		a.D.Scale(float64(len(a.Examples)) / n)
+	}
+	for range es {
+		a.D.P = append(a.D.P, 1.0/n)
+	}
+	a.Examples = append(a.Examples, es...)
+	a.ResetCache()
+	if l, ok := a.Learner.(interface {
+		AddExamples([]Example)
+	}); ok {
+		l.AddExamples(es)
+	}
 }
 
 func (a *AdaBoost) Predict(e Example) float64 {
@@ -89,6 +383,59 @@ func (a *AdaBoost) Predict(e Example) float64 {
 	return sum
 }
 
+// PredictBatch returns Predict for each of examples, scoring them in
+// parallel. The classifiers must be safe to call from multiple
+// goroutines.
+func (a *AdaBoost) PredictBatch(examples []Example) []float64 {
+	scores := make([]float64, len(examples))
+	parallel(len(examples), func(i int) {
+		scores[i] = a.Predict(examples[i])
+	})
+	return scores
+}
+
+// PredictProba returns the probability that e is in the positive
+// class, 1/(1+exp(-2*Predict(e))). This is the logistic calibration of
+// the margin returned by Predict, which AdaBoost's exponential loss
+// implies.
+func (a *AdaBoost) PredictProba(e Example) float64 {
+	return 1.0 / (1.0 + math.Exp(-2.0*a.Predict(e)))
+}
+
+// Margins returns the normalized margin of each example: its label
+// (+1 or -1) times Predict, divided by the sum of the magnitudes of
+// the classifier weights. This lies in [-1, 1]; it is positive when
+// the example is classified correctly, and larger the more confident
+// the ensemble is.
+func (a *AdaBoost) Margins(examples []Example) []float64 {
+	sum := 0.0
+	for _, a_t := range a.A {
+		sum += math.Abs(a_t)
+	}
+	margins := make([]float64, len(examples))
+	if sum == 0.0 {
+		return margins
+	}
+	for i, example := range examples {
+		margins[i] = float64OfLabel(example.Label()) * a.Predict(example) / sum
+	}
+	return margins
+}
+
+// LossGradient returns the gradient of the exponential loss
+// exp(-y F(x)) with respect to the score F(x) = Predict(x) at each
+// example, -y exp(-y F(x)), where y is +1 or -1. These are the
+// pseudo-residuals the next round fits; normalized, their magnitudes
+// are the weights D would have on the examples.
+func (a *AdaBoost) LossGradient(examples []Example) []float64 {
+	gradient := make([]float64, len(examples))
+	for i, score := range a.PredictBatch(examples) {
+		y := float64OfLabel(examples[i].Label())
+		gradient[i] = -y * math.Exp(-y*score)
+	}
+	return gradient
+}
+
 func DebugCharacterizeWeights(name string, ws []float64) {
 	min := math.MaxFloat64
 	max := 1.0 - math.MaxFloat64
@@ -102,16 +449,160 @@ func DebugCharacterizeWeights(name string, ws []float64) {
 }
 
 // Evaluates the classifier on a test set and returns the error rate.
+// The examples are scored in parallel with PredictBatch.
 func (a *AdaBoost) Evaluate(test []Example) float64 {
-	var scores []float64
-	mispredictions := 0
-	for _, example := range test {
-		score := a.Predict(example)
-		if Label(score > 0.0) != example.Label() {
-			mispredictions++
-		}
-		scores = append(scores, score)
+	scores := a.PredictBatch(test)
+	var m ConfusionMatrix
+	for i, score := range scores {
+		m.add(Label(score > 0.0), test[i].Label())
 	}
 	DebugCharacterizeWeights("scores", scores)
-	return float64(mispredictions) / float64(len(test))
+	return m.ErrorRate()
+}
+
+// SelectionHistory returns the learner's record of the classifier it
+// picked each round, if it keeps one, as DecisionStumper does, and nil
+// otherwise.
+func (a *AdaBoost) SelectionHistory() []Selection {
+	if l, ok := a.Learner.(interface {
+		SelectionHistory() []Selection
+	}); ok {
+		return l.SelectionHistory()
+	}
+	return nil
+}
+
+// ClassifierCount returns the number of classifiers in the ensemble.
+func (a *AdaBoost) ClassifierCount() int {
+	return len(a.H)
+}
+
+// Classifiers returns a copy of the classifiers in the ensemble.
+func (a *AdaBoost) Classifiers() []Classifier {
+	return append([]Classifier(nil), a.H...)
+}
+
+// Truncate keeps only the first n classifiers in the ensemble. It is
+// an error to keep more classifiers than there are.
+func (a *AdaBoost) Truncate(n int) error {
+	if n < 0 || n > len(a.H) {
+		return fmt.Errorf("cannot truncate %d classifiers to %d", len(a.H), n)
+	}
+	a.H = a.H[:n]
+	a.A = a.A[:n]
+	if len(a.TrainErrors) > n {
+		a.TrainErrors = a.TrainErrors[:n]
+	}
+	a.ResetCache()
+	return nil
+}
+
+// FeatureImportance returns, for each feature used by the ensemble, the
+// sum of the weights of the classifiers which use it, keyed by feature
+// ID so that equal features count together and a loaded model gives the
+// same result as the one saved. Conjunctions, negations and trees
+// credit the features they are built from.
+func (a *AdaBoost) FeatureImportance() map[string]float64 {
+	importance := make(map[string]float64)
+	for i, h := range a.H {
+		used := make(map[string]bool)
+		for _, f := range Features(h) {
+			if id := f.ID(); !used[id] {
+				importance[id] += math.Abs(a.A[i])
+				used[id] = true
+			}
+		}
+	}
+	return importance
+}
+
+// Features returns the underlying features a classifier is built
+// from, looking through the And, Or and Not combinators, abstaining
+// stumps and decision trees. A feature used more than once appears
+// more than once. Other classifiers which are features are returned
+// as themselves, and classifiers which are not have no features.
+func Features(c Classifier) []Feature {
+	switch c := c.(type) {
+	case *andFeature:
+		return append(Features(c.f1), Features(c.f2)...)
+	case *orFeature:
+		return append(Features(c.f1), Features(c.f2)...)
+	case *FeatureNegater:
+		return Features(c.Feature)
+	case *abstainingStump:
+		return Features(c.feature)
+	case *FeatureNode:
+		fs := Features(c.feature)
+		fs = append(fs, Features(c.positive)...)
+		return append(fs, Features(c.negative)...)
+	case Feature:
+		return []Feature{c}
+	}
+	return nil
+}
+
+// Validate checks the ensemble's invariants and returns an error
+// describing the first violation: there must be a weight for each
+// classifier, the weights must be finite, and no classifier or
+// feature within one may be nil. If the ensemble has a distribution
+// it must have an item for each example and sum to within 1e-6 of
+// 1.0. A loaded model has no distribution.
+func (a *AdaBoost) Validate() error {
+	if len(a.H) != len(a.A) {
+		return fmt.Errorf("%d classifiers but %d weights", len(a.H), len(a.A))
+	}
+	for i, h := range a.H {
+		if math.IsNaN(a.A[i]) || math.IsInf(a.A[i], 0) {
+			return fmt.Errorf("classifier %d has weight %f", i, a.A[i])
+		}
+		if err := validateClassifier(h); err != nil {
+			return fmt.Errorf("classifier %d: %v", i, err)
+		}
+	}
+	if a.D != nil {
+		if len(a.D.P) != len(a.Examples) {
+			return fmt.Errorf("distribution has %d items for %d examples", len(a.D.P), len(a.Examples))
+		}
+		sum := 0.0
+		for _, p := range a.D.P {
+			sum += p
+		}
+		if len(a.D.P) > 0 && math.Abs(sum-1.0) > 1e-6 {
+			return fmt.Errorf("distribution sums to %f, not 1.0", sum)
+		}
+	}
+	return nil
+}
+
+func validateClassifier(c Classifier) error {
+	switch c := c.(type) {
+	case nil:
+		return errors.New("nil classifier")
+	case *andFeature:
+		if err := validateClassifier(c.f1); err != nil {
+			return err
+		}
+		return validateClassifier(c.f2)
+	case *orFeature:
+		if err := validateClassifier(c.f1); err != nil {
+			return err
+		}
+		return validateClassifier(c.f2)
+	case *FeatureNegater:
+		return validateClassifier(c.Feature)
+	case *abstainingStump:
+		if math.IsNaN(c.vote) {
+			return errors.New("abstaining stump votes NaN")
+		}
+		return validateClassifier(c.feature)
+	case *FeatureNode:
+		if err := validateClassifier(c.feature); err != nil {
+			return err
+		}
+		if err := validateClassifier(c.positive); err != nil {
+			return err
+		}
+		return validateClassifier(c.negative)
+	}
+	return nil
 }
@@ -14,6 +14,17 @@ type LeafNode struct {
 	class bool
 }
 
+// NewFeatureNode returns a tree which predicts with positive on the
+// examples f fires on and with negative on the rest.
+func NewFeatureNode(f Feature, positive Classifier, negative Classifier) *FeatureNode {
+	return &FeatureNode{f, positive, negative}
+}
+
+// NewLeafNode returns a tree which predicts class for every example.
+func NewLeafNode(class Label) *LeafNode {
+	return &LeafNode{bool(class)}
+}
+
 type DecisionTreeBuilder struct {
 	features []Feature
 	maxDepth int
@@ -1,43 +1,491 @@
 package ml
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
+	"reflect"
+	"runtime"
+	"sync"
 )
 
 type DecisionStumper struct {
-	features []Feature
-	examples []Example
-	r *rand.Rand
+	features  []Feature
+	examples  []Example
+	r         *rand.Rand
+	noCache   bool
+	abstain   bool
+	subset    int
+	criterion SplitCriterion
+	priors    []float64
+	history   []Selection
+	// duplicates counts the features dropped for repeating an ID.
+	duplicates int
+
+	// fires[i][j] records whether features[i] fires on examples[j];
+	// index maps examples to j. Both are built on first use.
+	fires [][]bool
+	index map[Example]int
+
+	// Scratch space reused by each call to NewClassifier.
+	rowScratch []int
+	pool       []int
+	candidates [][2]int
+	counts     [][3]int
+}
+
+// Selection records the stump a DecisionStumper picked and its score:
+// its error or, for an abstaining stumper, Z, or the score from its
+// SplitCriterion, divided by any feature priors.
+type Selection struct {
+	Stump string
+	Score float64
 }
 
+// NewDecisionStumper returns a stumper which builds stumps from fs.
+// Features are considered identical if their IDs are, and only the
+// first of each is kept.
 func NewDecisionStumper(fs []Feature, es []Example, r *rand.Rand) *DecisionStumper {
-	return &DecisionStumper{fs, es, r}
+	features, duplicates := dedupFeatures(fs)
+	return &DecisionStumper{features: features, examples: es, r: r, duplicates: duplicates}
+}
+
+// sourceBatch is how many examples NewDecisionStumperFromSource reads
+// before evaluating the features on them.
+const sourceBatch = 1024
+
+// NewDecisionStumperFromSource is like NewDecisionStumper, but reads
+// its examples from src and fills in the feature cache as they arrive,
+// in one pass, rather than in a second pass over the examples on first
+// use. It returns the examples read, for NewAdaBoost; boosting still
+// needs them in memory to resample them each round.
+func NewDecisionStumperFromSource(fs []Feature, src ExampleSource, r *rand.Rand) (*DecisionStumper, []Example) {
+	stumper := NewDecisionStumper(fs, nil, r)
+	stumper.index = make(map[Example]int)
+	stumper.fires = make([][]bool, len(stumper.features))
+	batch := make([]Example, 0, sourceBatch)
+	for e, ok := src.Next(); ok; e, ok = src.Next() {
+		batch = append(batch, e)
+		if len(batch) == sourceBatch {
+			stumper.AddExamples(batch)
+			batch = batch[:0]
+		}
+	}
+	stumper.AddExamples(batch)
+	return stumper, stumper.examples
+}
+
+// NewDecisionStumperChecked is like NewDecisionStumper, but returns an
+// error if there are no features or no examples. NewDecisionStumper
+// accepts them, but NewClassifier panics without features and
+// boosting cannot sample from no examples.
+func NewDecisionStumperChecked(fs []Feature, es []Example, r *rand.Rand) (*DecisionStumper, error) {
+	if len(fs) == 0 {
+		return nil, errors.New("no features to build stumps from")
+	}
+	if len(es) == 0 {
+		return nil, errors.New("no examples to build stumps from")
+	}
+	return NewDecisionStumper(fs, es, r), nil
+}
+
+// NewAbstainingDecisionStumper is like NewDecisionStumper, but its
+// stumps vote only on the examples their feature fires on and abstain
+// on the rest. This is the abstaining variant of AdaBoost; the stumps
+// from NewDecisionStumper always vote, which is discrete AdaBoost.
+func NewAbstainingDecisionStumper(fs []Feature, es []Example, r *rand.Rand) *DecisionStumper {
+	stumper := NewDecisionStumper(fs, es, r)
+	stumper.abstain = true
+	return stumper
+}
+
+// dedupFeatures returns the first of each feature in fs with a given
+// ID, and how many features it dropped.
+func dedupFeatures(fs []Feature) ([]Feature, int) {
+	seen := make(map[string]bool)
+	var unique []Feature
+	for _, f := range fs {
+		if !seen[f.ID()] {
+			seen[f.ID()] = true
+			unique = append(unique, f)
+		}
+	}
+	return unique, len(fs) - len(unique)
+}
+
+// DuplicateFeatures returns how many of the features the stumper was
+// built with were dropped because an earlier feature had the same ID.
+func (stumper *DecisionStumper) DuplicateFeatures() int {
+	return stumper.duplicates
+}
+
+// SetCaching controls whether the stumper evaluates every feature on
+// every example once, on first use, and reuses the results in later
+// rounds. Caching is on by default; turn it off to save memory with
+// large feature sets. Caching requires examples to be comparable, for
+// example pointers; if any are not, the stumper turns caching off.
+func (stumper *DecisionStumper) SetCaching(enabled bool) {
+	stumper.noCache = !enabled
+	if !enabled {
+		stumper.fires = nil
+		stumper.index = nil
+	}
+}
+
+// AddExamples adds to the examples whose features are cached.
+func (stumper *DecisionStumper) AddExamples(es []Example) {
+	n := len(stumper.examples)
+	stumper.examples = append(stumper.examples, es...)
+	if stumper.fires == nil {
+		return
+	}
+	if !allHashable(es) {
+		stumper.SetCaching(false)
+		return
+	}
+	for j, example := range es {
+		stumper.index[example] = n + j
+	}
+	parallel(len(stumper.features), func(i int) {
+		for _, example := range es {
+			stumper.fires[i] = append(stumper.fires[i], !math.Signbit(stumper.features[i].Predict(example)))
+		}
+	})
+}
+
+// SelectionHistory returns the stump picked by each call to
+// NewClassifier, in order.
+func (stumper *DecisionStumper) SelectionHistory() []Selection {
+	return append([]Selection(nil), stumper.history...)
+}
+
+// SplitCriterion scores a candidate stump by the weight of the
+// examples it fires and does not fire on in each class; the stumper
+// picks the candidate with the lowest score. The weights are the
+// fractions of the round's examples, which are sampled by D, and sum
+// to 1.
+type SplitCriterion func(firedPositive, firedNegative, unfiredPositive, unfiredNegative float64) float64
+
+// GiniCriterion is the Gini impurity of the split: the Gini impurity
+// of the examples on each side, weighted by the weight of that side.
+func GiniCriterion(firedPositive, firedNegative, unfiredPositive, unfiredNegative float64) float64 {
+	gini := func(positive, negative float64) float64 {
+		w := positive + negative
+		if w == 0.0 {
+			return 0.0
+		}
+		return 2.0 * positive * negative / w
+	}
+	return gini(firedPositive, firedNegative) + gini(unfiredPositive, unfiredNegative)
+}
+
+// SetSplitCriterion makes NewClassifier pick stumps by criterion. If
+// criterion is nil the stumper minimizes the error or, for an
+// abstaining stumper, Z, which is the default. The criterion only
+// chooses between candidates; a chosen stump is still negated, or
+// votes, to agree with the majority of the examples it fires on.
+func (stumper *DecisionStumper) SetSplitCriterion(criterion SplitCriterion) {
+	stumper.criterion = criterion
+}
+
+// SetFeaturePriors biases the stumper towards features with larger
+// priors: each candidate's score is divided by the prior of its
+// feature or, for a conjunction, by the product of its features'
+// priors. Features are matched by ID and default to a prior of 1.0;
+// nil removes the priors. It is an error, and the priors are left
+// unchanged, if a prior is not positive and finite or is for a feature
+// the stumper does not have.
+func (stumper *DecisionStumper) SetFeaturePriors(priors map[Feature]float64) error {
+	if priors == nil {
+		stumper.priors = nil
+		return nil
+	}
+	known := make(map[string]bool, len(stumper.features))
+	for _, f := range stumper.features {
+		known[f.ID()] = true
+	}
+	byID := make(map[string]float64, len(priors))
+	for f, prior := range priors {
+		if !(prior > 0.0) || math.IsInf(prior, 1) {
+			return fmt.Errorf("prior of feature %s must be positive but was %g", f, prior)
+		}
+		if !known[f.ID()] {
+			return fmt.Errorf("prior for feature %s which the stumper does not have", f)
+		}
+		byID[f.ID()] = prior
+	}
+	stumper.priors = make([]float64, len(stumper.features))
+	for i, f := range stumper.features {
+		stumper.priors[i] = 1.0
+		if prior, ok := byID[f.ID()]; ok {
+			stumper.priors[i] = prior
+		}
+	}
+	return nil
+}
+
+// SetFeatureSubset makes each call to NewClassifier consider stumps
+// built from a random subset of m features, drawn afresh each round.
+// This is faster with very many features and regularizes the
+// ensemble. If m is zero, or at least the number of features, every
+// feature is considered, which is the default.
+func (stumper *DecisionStumper) SetFeatureSubset(m int) {
+	stumper.subset = m
+}
+
+// featurePool returns the indices of the features to consider this
+// round.
+func (stumper *DecisionStumper) featurePool() []int {
+	n := len(stumper.features)
+	if len(stumper.pool) != n {
+		stumper.pool = make([]int, n)
+		for i := range stumper.pool {
+			stumper.pool[i] = i
+		}
+	}
+	if stumper.subset <= 0 || stumper.subset >= n {
+		return stumper.pool
+	}
+	// A partial Fisher-Yates shuffle moves a random subset to the
+	// front.
+	for i := 0; i < stumper.subset; i++ {
+		j := i + stumper.r.Intn(n-i)
+		stumper.pool[i], stumper.pool[j] = stumper.pool[j], stumper.pool[i]
+	}
+	return stumper.pool[:stumper.subset]
 }
 
+// parallel calls f(i) for i in [0, n) across GOMAXPROCS goroutines.
+func parallel(n int, f func(i int)) {
+	var wg sync.WaitGroup
+	workers := runtime.GOMAXPROCS(0)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				f(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// hashable returns true if e can be used as a map key. Values holding
+// slices, maps or functions cannot.
+func hashable(e Example) bool {
+	return reflect.ValueOf(e).Comparable()
+}
+
+func allHashable(es []Example) bool {
+	for _, e := range es {
+		if !hashable(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// precompute builds the cache, unless some examples cannot be map
+// keys, in which case it turns caching off.
+func (stumper *DecisionStumper) precompute() {
+	if !allHashable(stumper.examples) {
+		stumper.SetCaching(false)
+		return
+	}
+	stumper.index = make(map[Example]int)
+	for j, example := range stumper.examples {
+		stumper.index[example] = j
+	}
+	stumper.fires = make([][]bool, len(stumper.features))
+	parallel(len(stumper.features), func(i int) {
+		fires := make([]bool, len(stumper.examples))
+		for j, example := range stumper.examples {
+			fires[j] = !math.Signbit(stumper.features[i].Predict(example))
+		}
+		stumper.fires[i] = fires
+	})
+}
+
+// rows returns the cache row of each example, or -1 if the example
+// is not cached.
+func (stumper *DecisionStumper) rows(examples []Example) []int {
+	rows := stumper.rowScratch[:0]
+	for _, example := range examples {
+		row := -1
+		if stumper.index != nil && hashable(example) {
+			if j, ok := stumper.index[example]; ok {
+				row = j
+			}
+		}
+		rows = append(rows, row)
+	}
+	stumper.rowScratch = rows
+	return rows
+}
+
+func (stumper *DecisionStumper) fire(i int, examples []Example, rows []int, k int) bool {
+	if rows[k] >= 0 {
+		return stumper.fires[i][rows[k]]
+	}
+	return !math.Signbit(stumper.features[i].Predict(examples[k]))
+}
+
+// pairCounts counts the examples the conjunction of features i and j
+// fires on, those of them which are positive, and the positive
+// examples overall.
+func (stumper *DecisionStumper) pairCounts(i, j int, examples []Example, rows []int) (counts [3]int) {
+	for k, example := range examples {
+		fires := stumper.fire(i, examples, rows, k) && stumper.fire(j, examples, rows, k)
+		if fires {
+			counts[0]++
+		}
+		if example.Label() {
+			counts[2]++
+			if fires {
+				counts[1]++
+			}
+		}
+	}
+	return
+}
+
+// score returns the error of a candidate stump with the given counts,
+// which NewClassifier minimizes.
+func (stumper *DecisionStumper) score(counts [3]int, nexamples int) float64 {
+	fired, firedPositive, positive := counts[0], counts[1], counts[2]
+	n := float64(nexamples)
+	if stumper.criterion != nil {
+		firedNegative := fired - firedPositive
+		unfiredPositive := positive - firedPositive
+		unfiredNegative := nexamples - fired - unfiredPositive
+		return stumper.criterion(float64(firedPositive)/n, float64(firedNegative)/n, float64(unfiredPositive)/n, float64(unfiredNegative)/n)
+	}
+	if stumper.abstain {
+		// Minimize Z = W_0 + 2 sqrt(W_+ W_-).
+		firedNegative := fired - firedPositive
+		return float64(nexamples-fired)/n + 2*math.Sqrt(float64(firedPositive)*float64(firedNegative))/n
+	}
+	error, _ := stumpError(counts, nexamples)
+	return error
+}
+
+// stumpError returns the error of a candidate stump with the given
+// counts, after negating it if its error is above 0.5, and whether it
+// is negated.
+func stumpError(counts [3]int, nexamples int) (float64, bool) {
+	fired, firedPositive, positive := counts[0], counts[1], counts[2]
+	error := float64(fired-firedPositive+positive-firedPositive) / float64(nexamples)
+	if error > 0.5 {
+		return 1.0 - error, true
+	}
+	return error, false
+}
+
+// stump builds the stump for a candidate with the given counts.
+func (stumper *DecisionStumper) stump(candidate [2]int, counts [3]int, nexamples int) Feature {
+	var feature Feature = stumper.features[candidate[0]]
+	if candidate[0] != candidate[1] {
+		feature = &andFeature{feature, stumper.features[candidate[1]]}
+	}
+	if stumper.abstain {
+		// Vote with the majority of the examples the feature fires on.
+		fired, firedPositive := counts[0], counts[1]
+		if 2*firedPositive >= fired {
+			return &abstainingStump{feature, 1.0}
+		}
+		return &abstainingStump{feature, -1.0}
+	}
+	if _, negate := stumpError(counts, nexamples); negate {
+		return &FeatureNegater{feature}
+	}
+	return feature
+}
+
+// NewClassifier picks the best stump for examples. Candidate stumps
+// are evaluated in parallel, so features must be safe to call from
+// multiple goroutines. The stumper itself reuses scratch space between
+// calls, so it must not be used by more than one goroutine at a time.
 func (stumper *DecisionStumper) NewClassifier(examples []Example) Classifier {
-	var bestStump Feature = nil
-	bestError := 1.0
+	if !stumper.noCache && stumper.fires == nil {
+		stumper.precompute()
+	}
+	rows := stumper.rows(examples)
 
-	// Consider random pairs of features as stumps.
+	// Consider random pairs of features as stumps. The pairs are
+	// drawn up front so the result does not depend on scheduling.
+	pool := stumper.featurePool()
+	candidates := stumper.candidates[:0]
 	for i := 0; i < 1000; i++ {
-		f1 := stumper.features[stumper.r.Intn(len(stumper.features))]
-		f2 := stumper.features[stumper.r.Intn(len(stumper.features))]
-		var feature Feature = &andFeature{f1, f2}
+		f1 := pool[stumper.r.Intn(len(pool))]
+		f2 := pool[stumper.r.Intn(len(pool))]
+		candidates = append(candidates, [2]int{f1, f2})
+	}
+	stumper.candidates = candidates
 
-		error := evaluateClassifier(feature, examples)
+	counts := stumper.counts[:0]
+	for range candidates {
+		counts = append(counts, [3]int{})
+	}
+	stumper.counts = counts
+	parallel(len(candidates), func(i int) {
+		counts[i] = stumper.pairCounts(candidates[i][0], candidates[i][1], examples, rows)
+	})
 
-		if error > 0.5 {
-			feature = &FeatureNegater{feature}
-			error = 1.0 - error
+	// Ties go to the first candidate, except that a single feature
+	// beats a conjunction.
+	best := -1
+	bestError := 1.0
+	bestSingle := false
+	for i, candidate := range candidates {
+		single := candidate[0] == candidate[1]
+		error := stumper.score(counts[i], len(examples))
+		if stumper.priors != nil {
+			prior := stumper.priors[candidate[0]]
+			if !single {
+				prior *= stumper.priors[candidate[1]]
+			}
+			error /= prior
 		}
-
-		if bestStump == nil || error < bestError {
-			bestStump = feature
+		if best == -1 || error < bestError || (error == bestError && single && !bestSingle) {
+			best = i
 			bestError = error
+			bestSingle = single
 		}
 	}
 
+	bestStump := stumper.stump(candidates[best], counts[best], len(examples))
 	fmt.Printf("Best stump %f: \"%s\"\n", bestError, bestStump)
+	stumper.history = append(stumper.history, Selection{bestStump.String(), bestError})
 	return bestStump
 }
+
+// abstainingStump predicts vote when its feature fires and abstains,
+// predicting 0.0, otherwise.
+type abstainingStump struct {
+	feature Feature
+	vote    float64
+}
+
+// NewAbstainingStump returns a stump which predicts vote on the
+// examples f fires on and abstains on the rest, like the stumps from
+// NewAbstainingDecisionStumper.
+func NewAbstainingStump(f Feature, vote float64) Classifier {
+	return &abstainingStump{f, vote}
+}
+
+func (s *abstainingStump) String() string {
+	return fmt.Sprintf("%s => %+.0f", s.feature, s.vote)
+}
+
+func (s *abstainingStump) ID() string {
+	return fmt.Sprintf("abstain(%s,%+.0f)", s.feature.ID(), s.vote)
+}
+
+func (s *abstainingStump) Predict(e Example) float64 {
+	if math.Signbit(s.feature.Predict(e)) {
+		return 0.0
+	}
+	return s.vote
+}
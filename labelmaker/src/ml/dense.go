@@ -0,0 +1,68 @@
+package ml
+
+import (
+	"fmt"
+)
+
+// DenseExample is an example which stores whether each of a fixed,
+// ordered set of features is present, for fast lookup by position.
+type DenseExample struct {
+	values []bool
+	label  Label
+}
+
+// NewDenseExample returns a DenseExample with a value for each token
+// in vocabulary: true if it is in tokens. Tokens which are not in
+// vocabulary are ignored.
+func NewDenseExample(vocabulary []string, tokens []string, label Label) *DenseExample {
+	present := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		present[token] = true
+	}
+	values := make([]bool, len(vocabulary))
+	for i, token := range vocabulary {
+		values[i] = present[token]
+	}
+	return &DenseExample{values, label}
+}
+
+func (e *DenseExample) Label() Label {
+	return e.label
+}
+
+// Value returns whether the feature at position i is present.
+func (e *DenseExample) Value(i int) bool {
+	return e.values[i]
+}
+
+// IndexFeature fires on DenseExamples whose value at Index is true.
+type IndexFeature struct {
+	Index int
+	Name  string
+}
+
+func (f *IndexFeature) String() string {
+	return f.Name
+}
+
+func (f *IndexFeature) ID() string {
+	return fmt.Sprintf("index(%d:%s)", f.Index, f.Name)
+}
+
+func (f *IndexFeature) Predict(e Example) float64 {
+	if e.(*DenseExample).values[f.Index] {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
+
+// IndexFeatures returns an IndexFeature for each position in
+// vocabulary, named by its token.
+func IndexFeatures(vocabulary []string) []Feature {
+	features := make([]Feature, len(vocabulary))
+	for i, token := range vocabulary {
+		features[i] = &IndexFeature{i, token}
+	}
+	return features
+}
@@ -0,0 +1,104 @@
+package ml
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Rule describes a classifier as an if/else rule, for example
+// "IF title*crash THEN +1 ELSE -1".
+func Rule(c Classifier) string {
+	switch c := c.(type) {
+	case *FeatureNode:
+		return fmt.Sprintf("IF %s THEN (%s) ELSE (%s)", c.feature, Rule(c.positive), Rule(c.negative))
+	case *LeafNode:
+		return fmt.Sprintf("%+.0f", c.Predict(nil))
+	case *abstainingStump:
+		return fmt.Sprintf("IF %s THEN %+.0f ELSE 0", c.feature, c.vote)
+	case Feature:
+		return fmt.Sprintf("IF %s THEN +1 ELSE -1", c)
+	}
+	return fmt.Sprintf("%v", c)
+}
+
+// Rules describes each classifier in the ensemble, with its weight.
+func (a *AdaBoost) Rules() []string {
+	rules := make([]string, len(a.H))
+	for i, h := range a.H {
+		rules[i] = fmt.Sprintf("%f: %s", a.A[i], Rule(h))
+	}
+	return rules
+}
+
+// Contribution is the part one classifier in an ensemble contributed
+// to the score of an example.
+type Contribution struct {
+	// Round is the classifier's index in the ensemble.
+	Round int
+	// Rule describes the classifier.
+	Rule string
+	// Value is the classifier's weight times its prediction.
+	Value float64
+}
+
+// Explain returns each classifier's contribution to Predict(e), with
+// the largest in magnitude first. The values sum to Predict(e).
+func (a *AdaBoost) Explain(e Example) []Contribution {
+	contributions := make([]Contribution, len(a.H))
+	for i, h := range a.H {
+		contributions[i] = Contribution{i, Rule(h), a.A[i] * h.Predict(e)}
+	}
+	sort.SliceStable(contributions, func(i, j int) bool {
+		return math.Abs(contributions[i].Value) > math.Abs(contributions[j].Value)
+	})
+	return contributions
+}
+
+// WriteDOT writes the ensemble to w as a Graphviz DOT graph, with a
+// cluster for each classifier. Trees are drawn with their branches;
+// other classifiers are drawn as a single node showing their Rule.
+func (a *AdaBoost) WriteDOT(w io.Writer) error {
+	d := &dotWriter{w: w}
+	d.printf("digraph ensemble {\n")
+	for i, h := range a.H {
+		d.printf("  subgraph cluster_%d {\n", i)
+		d.printf("    label=%q;\n", fmt.Sprintf("round %d, weight %f", i, a.A[i]))
+		d.node(h)
+		d.printf("  }\n")
+	}
+	d.printf("}\n")
+	return d.err
+}
+
+type dotWriter struct {
+	w     io.Writer
+	nodes int
+	err   error
+}
+
+func (d *dotWriter) printf(format string, args ...interface{}) {
+	if d.err == nil {
+		_, d.err = fmt.Fprintf(d.w, format, args...)
+	}
+}
+
+// node writes a node for c and returns the node's name.
+func (d *dotWriter) node(c Classifier) string {
+	name := fmt.Sprintf("n%d", d.nodes)
+	d.nodes++
+	switch c := c.(type) {
+	case *FeatureNode:
+		d.printf("    %s [label=%q];\n", name, c.feature.String())
+		positive := d.node(c.positive)
+		negative := d.node(c.negative)
+		d.printf("    %s -> %s [label=\"yes\"];\n", name, positive)
+		d.printf("    %s -> %s [label=\"no\"];\n", name, negative)
+	case *LeafNode:
+		d.printf("    %s [label=%q, shape=plaintext];\n", name, Rule(c))
+	default:
+		d.printf("    %s [label=%q, shape=box];\n", name, Rule(c))
+	}
+	return name
+}
@@ -1,7 +1,13 @@
 package ml
 
 import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 )
 
 type Distribution struct {
@@ -21,14 +27,310 @@ func UniformDistribution(nitems int) *Distribution {
 	return &Distribution{distribution}
 }
 
-func (d *Distribution) Normalize() {
+// NewDistributionFromCounts returns a new distribution proportional
+// to counts. It is an error for any count to be negative or for all
+// of the counts to be zero.
+func NewDistributionFromCounts(counts []float64) (*Distribution, error) {
+	distribution := make([]float64, len(counts), len(counts))
+	for i, count := range counts {
+		if count < 0.0 {
+			return nil, fmt.Errorf("count %d is negative: %f", i, count)
+		}
+		distribution[i] = count
+	}
+	dist := &Distribution{distribution}
+	if err := dist.Normalize(); err != nil {
+		return nil, err
+	}
+	return dist, nil
+}
+
+// SoftmaxDistribution returns the distribution proportional to
+// exp(logit/temperature). Low temperatures concentrate the mass on the
+// largest logits and high temperatures approach uniform; a temperature
+// of zero or less puts all of the mass on the first largest logit. No
+// logits give an empty distribution.
+func SoftmaxDistribution(logits []float64, temperature float64) *Distribution {
+	if len(logits) == 0 {
+		return &Distribution{[]float64{}}
+	}
+	max := (&Distribution{logits}).Argmax()
+	distribution := make([]float64, len(logits), len(logits))
+	if temperature <= 0.0 {
+		distribution[max] = 1.0
+		return &Distribution{distribution}
+	}
+	// Subtracting the largest logit avoids overflow.
+	for i, logit := range logits {
+		distribution[i] = math.Exp((logit - logits[max]) / temperature)
+	}
+	dist := &Distribution{distribution}
+	dist.Normalize()
+	return dist
+}
+
+// Normalize scales the distribution in place so that it sums to
+// 1.0. If the distribution sums to zero it is left untouched and an
+// error is returned.
+func (d *Distribution) Normalize() error {
 	sum := 0.0
 	for _, v := range d.P {
 		sum += v
 	}
+	if sum == 0.0 {
+		return errors.New("cannot normalize a distribution which sums to zero")
+	}
 	for i, _ := range d.P {
 		d.P[i] /= sum
 	}
+	return nil
+}
+
+type distributionJson struct {
+	P []float64 `json:"p"`
+}
+
+// MarshalJSON encodes the distribution as {"p": [...]}.
+func (dist *Distribution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(distributionJson{dist.P})
+}
+
+// UnmarshalJSON decodes a distribution encoded by MarshalJSON. It is
+// an error for the distribution to be empty or to contain negative
+// values.
+func (dist *Distribution) UnmarshalJSON(data []byte) error {
+	var d distributionJson
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	if len(d.P) == 0 {
+		return errors.New("distribution is empty")
+	}
+	for i, p := range d.P {
+		if math.IsNaN(p) || p < 0.0 {
+			return fmt.Errorf("item %d of distribution is invalid: %f", i, p)
+		}
+	}
+	dist.P = d.P
+	return nil
+}
+
+// Clone returns a copy of the distribution which does not share P.
+func (dist *Distribution) Clone() *Distribution {
+	return &Distribution{append([]float64(nil), dist.P...)}
+}
+
+// ApproxEqual returns true if p and q have the same length and each
+// of their items differ by at most tol.
+func (p *Distribution) ApproxEqual(q *Distribution, tol float64) bool {
+	if len(p.P) != len(q.P) {
+		return false
+	}
+	for i, pi := range p.P {
+		if math.Abs(pi-q.P[i]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// Argmax returns the index of the most probable item. Ties are broken
+// in favor of the lowest index. It returns -1 if the distribution is
+// empty.
+func (dist *Distribution) Argmax() int {
+	if len(dist.P) == 0 {
+		return -1
+	}
+	best := 0
+	for i, p := range dist.P {
+		if p > dist.P[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// Max returns the probability of the most probable item. It returns
+// 0.0 if the distribution is empty.
+func (dist *Distribution) Max() float64 {
+	if len(dist.P) == 0 {
+		return 0.0
+	}
+	return dist.P[dist.Argmax()]
+}
+
+// TopK returns the indices of the k most probable items, most probable
+// first. Ties are broken in favor of the lowest index. If k exceeds
+// the number of items all of the indices are returned.
+func (dist *Distribution) TopK(k int) []int {
+	if k > len(dist.P) {
+		k = len(dist.P)
+	}
+	if k <= 0 {
+		return nil
+	}
+
+	// Keep the best k items seen so far in a heap with the worst of
+	// them on top.
+	h := &topKHeap{dist.P, make([]int, 0, k)}
+	for i := range dist.P {
+		if h.Len() < k {
+			heap.Push(h, i)
+		} else if h.better(i, h.indices[0]) {
+			h.indices[0] = i
+			heap.Fix(h, 0)
+		}
+	}
+
+	top := make([]int, k)
+	for i := k - 1; i >= 0; i-- {
+		top[i] = heap.Pop(h).(int)
+	}
+	return top
+}
+
+type topKHeap struct {
+	p       []float64
+	indices []int
+}
+
+// better returns true if item i ranks ahead of item j.
+func (h *topKHeap) better(i, j int) bool {
+	return h.p[i] > h.p[j] || (h.p[i] == h.p[j] && i < j)
+}
+
+func (h *topKHeap) Len() int           { return len(h.indices) }
+func (h *topKHeap) Less(i, j int) bool { return h.better(h.indices[j], h.indices[i]) }
+func (h *topKHeap) Swap(i, j int)      { h.indices[i], h.indices[j] = h.indices[j], h.indices[i] }
+func (h *topKHeap) Push(x interface{}) { h.indices = append(h.indices, x.(int)) }
+
+func (h *topKHeap) Pop() interface{} {
+	n := len(h.indices)
+	x := h.indices[n-1]
+	h.indices = h.indices[:n-1]
+	return x
+}
+
+// Entropy returns the Shannon entropy of the distribution in nats.
+// Items with zero probability contribute nothing.
+func (dist *Distribution) Entropy() float64 {
+	h := 0.0
+	for _, p := range dist.P {
+		if p > 0.0 {
+			h -= p * math.Log(p)
+		}
+	}
+	return h
+}
+
+// KLDivergence returns the Kullback-Leibler divergence of q from p,
+// in nats. Items where p is zero contribute nothing; if q is zero
+// where p is not the divergence is +Inf. It is an error for the
+// distributions to have different lengths.
+func (p *Distribution) KLDivergence(q *Distribution) (float64, error) {
+	if len(p.P) != len(q.P) {
+		return 0.0, fmt.Errorf("distributions have different lengths %d and %d", len(p.P), len(q.P))
+	}
+	d := 0.0
+	for i, pi := range p.P {
+		if pi > 0.0 {
+			if q.P[i] == 0.0 {
+				return math.Inf(1), nil
+			}
+			d += pi * math.Log(pi/q.P[i])
+		}
+	}
+	return d, nil
+}
+
+// CrossEntropy returns -sum q_i log p_i, in nats: the cross-entropy
+// of p relative to the target q. Items where q is zero contribute
+// nothing; if p is zero where q is not the cross-entropy is +Inf. It
+// is an error for the distributions to have different lengths.
+func (p *Distribution) CrossEntropy(q *Distribution) (float64, error) {
+	if len(p.P) != len(q.P) {
+		return 0.0, fmt.Errorf("distributions have different lengths %d and %d", len(p.P), len(q.P))
+	}
+	h := 0.0
+	for i, qi := range q.P {
+		if qi > 0.0 {
+			if p.P[i] == 0.0 {
+				return math.Inf(1), nil
+			}
+			h -= qi * math.Log(p.P[i])
+		}
+	}
+	return h, nil
+}
+
+// Scale multiplies every item of the distribution by factor, in place.
+// It is an error, and the distribution is left untouched, if factor is
+// not positive or is NaN or infinite, since the result would not be a
+// distribution.
+func (dist *Distribution) Scale(factor float64) error {
+	if !(factor > 0.0) || math.IsInf(factor, 1) {
+		return fmt.Errorf("cannot scale a distribution by %g", factor)
+	}
+	for i := range dist.P {
+		dist.P[i] *= factor
+	}
+	return nil
+}
+
+// Mixture returns the normalized weighted sum of dists. It is an error
+// for the distributions to have different lengths or for there to be
+// a different number of weights and distributions.
+func Mixture(weights []float64, dists []*Distribution) (*Distribution, error) {
+	if len(weights) != len(dists) {
+		return nil, fmt.Errorf("%d weights for %d distributions", len(weights), len(dists))
+	}
+	if len(dists) == 0 {
+		return nil, errors.New("cannot mix zero distributions")
+	}
+	mixture := &Distribution{make([]float64, len(dists[0].P))}
+	for i, dist := range dists {
+		if len(dist.P) != len(mixture.P) {
+			return nil, fmt.Errorf("distributions have different lengths %d and %d", len(mixture.P), len(dist.P))
+		}
+		for j, p := range dist.P {
+			mixture.P[j] += weights[i] * p
+		}
+	}
+	if err := mixture.Normalize(); err != nil {
+		return nil, err
+	}
+	return mixture, nil
+}
+
+// Gini returns the Gini impurity of the distribution, 1 - sum(p_i^2).
+func (dist *Distribution) Gini() float64 {
+	g := 1.0
+	for _, p := range dist.P {
+		g -= p * p
+	}
+	return g
+}
+
+// Mean returns sum(i * p_i), interpreting each item's index as its
+// value. The distribution is assumed to be normalized.
+func (dist *Distribution) Mean() float64 {
+	mean := 0.0
+	for i, p := range dist.P {
+		mean += float64(i) * p
+	}
+	return mean
+}
+
+// Variance returns sum((i - mean)^2 * p_i), interpreting each item's
+// index as its value. The distribution is assumed to be normalized.
+func (dist *Distribution) Variance() float64 {
+	mean := dist.Mean()
+	v := 0.0
+	for i, p := range dist.P {
+		d := float64(i) - mean
+		v += d * d * p
+	}
+	return v
 }
 
 func CumulativeDistributionOfDistribution(dist *Distribution) *CumulativeDistribution {
@@ -42,26 +344,142 @@ func CumulativeDistributionOfDistribution(dist *Distribution) *CumulativeDistrib
 }
 
 // Sample draws a sample, weighted by a distribution, and returns the
-// index of the sample.
+// index of the sample. The draw is scaled by the total mass so that
+// distributions which drift slightly from summing to 1.0 still sample
+// correctly.
 func (dist *CumulativeDistribution) Sample(r *rand.Rand) int {
-	return search(r.Float64(), dist.P, 0, len(dist.P))
+	return dist.SampleFrom(r.Float64())
+}
+
+// SampleFrom is like Sample, but uses the caller's uniform draw u in
+// [0, 1) instead of drawing one, so the source of randomness can be
+// replayed or fixed. It returns the first index whose cumulative mass
+// exceeds the scaled draw, so items with no mass are never sampled.
+func (dist *CumulativeDistribution) SampleFrom(u float64) int {
+	s := u * dist.P[len(dist.P)-1]
+	i := sort.Search(len(dist.P), func(i int) bool { return dist.P[i] > s })
+	if i == len(dist.P) {
+		// Rounding put the draw at the total mass.
+		i--
+	}
+	return i
 }
 
-// search does a binary search to find the index i such that:
-// C_i-1 < sample <= C_i
-func search(s float64, cumulative []float64, startInclusive int, endExclusive int) int {
-	if startInclusive == endExclusive {
-		if !((s <= cumulative[0] && startInclusive == 0) || cumulative[startInclusive-1] < s) {
-			panic("Search did not find a valid index. Is the cumulative distribution valid?")
+// SampleChecked is like Sample, but returns an error rather than
+// sampling if the distribution is invalid: if it is empty, if any
+// item has negative probability, or if it does not sum to within
+// 1e-6 of 1.0.
+func (dist *CumulativeDistribution) SampleChecked(r *rand.Rand) (int, error) {
+	if len(dist.P) == 0 {
+		return -1, errors.New("cannot sample from an empty distribution")
+	}
+	prev := 0.0
+	for i, c := range dist.P {
+		if math.IsNaN(c) || c < prev {
+			return -1, fmt.Errorf("item %d of distribution has negative probability", i)
+		}
+		prev = c
+	}
+	if sum := dist.P[len(dist.P)-1]; math.Abs(sum-1.0) > 1e-6 {
+		return -1, fmt.Errorf("distribution sums to %f, not 1.0", sum)
+	}
+	return dist.Sample(r), nil
+}
+
+// AliasSampler draws samples from a fixed distribution in constant
+// time using Vose's alias method.
+type AliasSampler struct {
+	prob  []float64
+	alias []int
+}
+
+// NewAliasSampler builds an AliasSampler for the distribution. This
+// takes time linear in the number of items; prefer it to Sample when
+// drawing many samples from the same distribution. It is an error for
+// the distribution to be empty or to sum to zero.
+func (dist *Distribution) NewAliasSampler() (*AliasSampler, error) {
+	n := len(dist.P)
+	s := &AliasSampler{make([]float64, n), make([]int, n)}
+
+	sum := 0.0
+	for _, p := range dist.P {
+		sum += p
+	}
+	if n == 0 {
+		return nil, errors.New("cannot sample from an empty distribution")
+	}
+	if sum == 0.0 {
+		return nil, errors.New("cannot sample from a distribution which sums to zero")
+	}
+	scaled := make([]float64, n)
+	var small, large []int
+	for i, p := range dist.P {
+		scaled[i] = p * float64(n) / sum
+		if scaled[i] < 1.0 {
+			small = append(small, i)
+		} else {
+			large = append(large, i)
 		}
-		return startInclusive
 	}
-	mid := startInclusive + (endExclusive-startInclusive)/2
-	if s <= cumulative[mid] {
-		return search(s, cumulative, startInclusive, mid)
+
+	for len(small) > 0 && len(large) > 0 {
+		l := small[len(small)-1]
+		small = small[:len(small)-1]
+		g := large[len(large)-1]
+		large = large[:len(large)-1]
+
+		s.prob[l] = scaled[l]
+		s.alias[l] = g
+		scaled[g] = (scaled[g] + scaled[l]) - 1.0
+		if scaled[g] < 1.0 {
+			small = append(small, g)
+		} else {
+			large = append(large, g)
+		}
+	}
+	// Anything left over is only short of 1.0 due to rounding.
+	for _, g := range large {
+		s.prob[g] = 1.0
+	}
+	for _, l := range small {
+		s.prob[l] = 1.0
+	}
+	return s, nil
+}
+
+// Sample draws a sample and returns the index of the sample.
+func (s *AliasSampler) Sample(r *rand.Rand) int {
+	i := r.Intn(len(s.prob))
+	if r.Float64() < s.prob[i] {
+		return i
 	}
-	if mid == startInclusive {
-		mid++
+	return s.alias[i]
+}
+
+// SampleN draws k distinct indices, weighted by the distribution. The
+// remaining items are renormalized after each draw. It is an error to
+// ask for more samples than there are items with non-zero probability.
+func (dist *Distribution) SampleN(r *rand.Rand, k int) ([]int, error) {
+	nonzero := 0
+	for _, p := range dist.P {
+		if p > 0.0 {
+			nonzero++
+		}
+	}
+	if k > nonzero {
+		return nil, fmt.Errorf("cannot draw %d distinct samples from %d items with non-zero probability", k, nonzero)
+	}
+
+	remaining := &Distribution{make([]float64, len(dist.P))}
+	copy(remaining.P, dist.P)
+	var samples []int
+	for len(samples) < k {
+		if err := remaining.Normalize(); err != nil {
+			return nil, err
+		}
+		i := CumulativeDistributionOfDistribution(remaining).Sample(r)
+		samples = append(samples, i)
+		remaining.P[i] = 0.0
 	}
-	return search(s, cumulative, mid, endExclusive)
+	return samples, nil
 }
@@ -0,0 +1,285 @@
+package ml
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ConfusionMatrix counts a classifier's predictions on a test set.
+type ConfusionMatrix struct {
+	TP int `json:"tp"`
+	FP int `json:"fp"`
+	FN int `json:"fn"`
+	TN int `json:"tn"`
+}
+
+// ConfusionMatrix evaluates the classifier on a test set, treating a
+// positive score as predicting the positive class. The examples are
+// scored in parallel with PredictBatch.
+func (a *AdaBoost) ConfusionMatrix(test []Example) ConfusionMatrix {
+	var m ConfusionMatrix
+	for i, score := range a.PredictBatch(test) {
+		m.add(Label(score > 0.0), test[i].Label())
+	}
+	return m
+}
+
+func (m *ConfusionMatrix) add(predicted Label, actual Label) {
+	switch p, a := bool(predicted), bool(actual); {
+	case p && a:
+		m.TP++
+	case p && !a:
+		m.FP++
+	case !p && a:
+		m.FN++
+	default:
+		m.TN++
+	}
+}
+
+// Report returns Predict for each test example, keyed by the
+// example's ID if it is Identifiable and by its index in test
+// otherwise. Examples with the same ID share a key and the last one
+// is reported.
+func (a *AdaBoost) Report(test []Example) map[string]float64 {
+	report := make(map[string]float64, len(test))
+	for i, score := range a.PredictBatch(test) {
+		key := strconv.Itoa(i)
+		if e, ok := test[i].(Identifiable); ok {
+			key = e.ID()
+		}
+		report[key] = score
+	}
+	return report
+}
+
+// TuneThreshold returns the threshold on Predict which maximizes the
+// F1 of the positive class on val, for use with PredictWithThreshold.
+// The candidates are the midpoints between consecutive distinct
+// scores and a threshold below all of them. It returns 0.0, the
+// threshold Predict implies, unless some candidate does strictly
+// better.
+func (a *AdaBoost) TuneThreshold(val []Example) float64 {
+	scores := a.PredictBatch(val)
+	order := rankByScore(scores)
+	positives := 0
+	var m ConfusionMatrix
+	for i, example := range val {
+		if example.Label() {
+			positives++
+		}
+		m.add(Label(scores[i] > 0.0), example.Label())
+	}
+
+	best, bestF1 := 0.0, m.F1()[true]
+	tp := 0
+	for i, k := range order {
+		if val[k].Label() {
+			tp++
+		}
+		if i+1 < len(order) && scores[order[i+1]] == scores[k] {
+			continue
+		}
+		// Predict the first i+1 examples positive.
+		threshold := scores[k] - 1.0
+		if i+1 < len(order) {
+			threshold = (scores[k] + scores[order[i+1]]) / 2.0
+		}
+		if f1 := ratio(2*tp, i+1+positives); f1 > bestF1 {
+			best, bestF1 = threshold, f1
+		}
+	}
+	return best
+}
+
+// PredictWithThreshold returns the class e is in when the positive
+// class is predicted for scores above threshold rather than above 0.0.
+func (a *AdaBoost) PredictWithThreshold(e Example, threshold float64) Label {
+	return Label(a.Predict(e) > threshold)
+}
+
+// ratio returns n/d, or 0.0 if d is zero.
+func ratio(n int, d int) float64 {
+	if d == 0 {
+		return 0.0
+	}
+	return float64(n) / float64(d)
+}
+
+// Precision returns, for each class, the fraction of the examples
+// predicted to be in the class which are. It is 0.0 for a class which
+// was never predicted.
+func (m ConfusionMatrix) Precision() map[Label]float64 {
+	return map[Label]float64{
+		true:  ratio(m.TP, m.TP+m.FP),
+		false: ratio(m.TN, m.TN+m.FN),
+	}
+}
+
+// Recall returns, for each class, the fraction of the examples in the
+// class which were predicted to be. It is 0.0 for a class with no
+// examples.
+func (m ConfusionMatrix) Recall() map[Label]float64 {
+	return map[Label]float64{
+		true:  ratio(m.TP, m.TP+m.FN),
+		false: ratio(m.TN, m.TN+m.FP),
+	}
+}
+
+// F1 returns, for each class, the harmonic mean of its precision and
+// recall. It is 0.0 if both are.
+func (m ConfusionMatrix) F1() map[Label]float64 {
+	return map[Label]float64{
+		true:  ratio(2*m.TP, 2*m.TP+m.FP+m.FN),
+		false: ratio(2*m.TN, 2*m.TN+m.FN+m.FP),
+	}
+}
+
+// Accuracy returns the fraction of examples predicted correctly. Since
+// every example is in exactly one class this is also the
+// micro-averaged precision, recall and F1.
+func (m ConfusionMatrix) Accuracy() float64 {
+	return ratio(m.TP+m.TN, m.TP+m.FP+m.FN+m.TN)
+}
+
+// ErrorRate returns the fraction of examples predicted incorrectly.
+func (m ConfusionMatrix) ErrorRate() float64 {
+	return ratio(m.FP+m.FN, m.TP+m.FP+m.FN+m.TN)
+}
+
+// MacroAverage returns the unweighted mean of a per-class metric.
+func MacroAverage(scores map[Label]float64) float64 {
+	sum := 0.0
+	for _, score := range scores {
+		sum += score
+	}
+	return sum / float64(len(scores))
+}
+
+// ROC returns the receiver operating characteristic of the classifier
+// on a test set: the true and false positive rates as the threshold on
+// the margin is lowered from above the highest score to below the
+// lowest. The curve starts at (0, 0) and ends at (1, 1), and examples
+// with equal scores move the curve together. If the test set has no
+// positive or no negative examples the rates are undefined and ROC
+// returns nil slices.
+func (a *AdaBoost) ROC(test []Example) (tpr, fpr []float64) {
+	scores := a.PredictBatch(test)
+	positives := LabelStats(test)[true]
+	negatives := len(test) - positives
+	if positives == 0 || negatives == 0 {
+		return nil, nil
+	}
+	order := rankByScore(scores)
+
+	tpr, fpr = []float64{0.0}, []float64{0.0}
+	tp, fp := 0, 0
+	for i, k := range order {
+		if test[k].Label() {
+			tp++
+		} else {
+			fp++
+		}
+		if i+1 < len(order) && scores[order[i+1]] == scores[k] {
+			continue
+		}
+		tpr = append(tpr, ratio(tp, positives))
+		fpr = append(fpr, ratio(fp, negatives))
+	}
+	return tpr, fpr
+}
+
+// rankByScore returns the indices of scores from highest score to
+// lowest. Equal scores keep their order.
+func rankByScore(scores []float64) []int {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	return order
+}
+
+// PrecisionAtK returns the fraction of the k highest scoring test
+// examples which are positive. If there are fewer than k examples it
+// is the fraction of all of them.
+func (a *AdaBoost) PrecisionAtK(test []Example, k int) float64 {
+	if k > len(test) {
+		k = len(test)
+	}
+	return ratio(a.positivesAtK(test, k), k)
+}
+
+// RecallAtK returns the fraction of the positive test examples which
+// are among the k highest scoring. It is 0.0 if there are no positive
+// examples.
+func (a *AdaBoost) RecallAtK(test []Example, k int) float64 {
+	return ratio(a.positivesAtK(test, k), LabelStats(test)[true])
+}
+
+// positivesAtK returns the number of positive examples among the k
+// highest scoring.
+func (a *AdaBoost) positivesAtK(test []Example, k int) int {
+	positives := 0
+	for i, j := range rankByScore(a.PredictBatch(test)) {
+		if i >= k {
+			break
+		}
+		if test[j].Label() {
+			positives++
+		}
+	}
+	return positives
+}
+
+// AUC returns the area under a curve returned by ROC using the
+// trapezoidal rule. It returns NaN for the nil curve ROC returns when
+// the test set has only one class.
+func AUC(tpr, fpr []float64) float64 {
+	if len(tpr) == 0 || len(tpr) != len(fpr) {
+		return math.NaN()
+	}
+	area := 0.0
+	for i := 1; i < len(tpr); i++ {
+		area += (fpr[i] - fpr[i-1]) * (tpr[i] + tpr[i-1]) / 2.0
+	}
+	return area
+}
+
+// ClassMetrics are the metrics of one class.
+type ClassMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// Metrics collects the evaluation metrics of a classifier on a test
+// set for reporting, for example as JSON.
+type Metrics struct {
+	Examples  int             `json:"examples"`
+	Counts    ConfusionMatrix `json:"counts"`
+	ErrorRate float64         `json:"error_rate"`
+	Accuracy  float64         `json:"accuracy"`
+	MacroF1   float64         `json:"macro_f1"`
+	Positive  ClassMetrics    `json:"positive"`
+	Negative  ClassMetrics    `json:"negative"`
+}
+
+// EvaluateAll scores the test set once and computes every metric from
+// the resulting confusion matrix.
+func (a *AdaBoost) EvaluateAll(test []Example) Metrics {
+	m := a.ConfusionMatrix(test)
+	precision, recall, f1 := m.Precision(), m.Recall(), m.F1()
+	return Metrics{
+		Examples:  len(test),
+		Counts:    m,
+		ErrorRate: m.ErrorRate(),
+		Accuracy:  m.Accuracy(),
+		MacroF1:   MacroAverage(f1),
+		Positive:  ClassMetrics{precision[true], recall[true], f1[true]},
+		Negative:  ClassMetrics{precision[false], recall[false], f1[false]},
+	}
+}
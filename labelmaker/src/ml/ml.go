@@ -3,6 +3,8 @@ package ml
 import (
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
 )
 
 type Label bool
@@ -11,12 +13,29 @@ type Example interface {
 	Label() Label
 }
 
+// Identifiable is implemented by examples which can be traced back to
+// a source record, for reports keyed by example.
+type Identifiable interface {
+	ID() string
+}
+
 type Feature interface {
 	// String returns a human-readable description of the feature.
 	String() string
+	// ID returns a stable key identifying the feature. Features with
+	// the same ID are considered identical.
+	ID() string
 	Predict(Example) float64
 }
 
+// FeatureID can be embedded in a feature to implement ID with a fixed
+// string.
+type FeatureID string
+
+func (id FeatureID) ID() string {
+	return string(id)
+}
+
 type andFeature struct {
 	f1 Feature
 	f2 Feature
@@ -26,6 +45,10 @@ func (f *andFeature) String() string {
 	return fmt.Sprintf("%s && %s", f.f1, f.f2)
 }
 
+func (f *andFeature) ID() string {
+	return fmt.Sprintf("and(%s,%s)", f.f1.ID(), f.f2.ID())
+}
+
 func (f *andFeature) Predict(e Example) float64 {
 	if !math.Signbit(f.f1.Predict(e)) && !math.Signbit(f.f2.Predict(e)) {
 		return 1.0
@@ -34,14 +57,169 @@ func (f *andFeature) Predict(e Example) float64 {
 	}
 }
 
+type orFeature struct {
+	f1 Feature
+	f2 Feature
+}
+
+func (f *orFeature) String() string {
+	return fmt.Sprintf("%s || %s", f.f1, f.f2)
+}
+
+func (f *orFeature) ID() string {
+	return fmt.Sprintf("or(%s,%s)", f.f1.ID(), f.f2.ID())
+}
+
+func (f *orFeature) Predict(e Example) float64 {
+	if !math.Signbit(f.f1.Predict(e)) || !math.Signbit(f.f2.Predict(e)) {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
+
+// And returns a feature which fires when all of fs fire. It panics if
+// fs is empty.
+func And(fs ...Feature) Feature {
+	f := fs[0]
+	for _, g := range fs[1:] {
+		f = &andFeature{f, g}
+	}
+	return f
+}
+
+// Or returns a feature which fires when any of fs fire. It panics if
+// fs is empty.
+func Or(fs ...Feature) Feature {
+	f := fs[0]
+	for _, g := range fs[1:] {
+		f = &orFeature{f, g}
+	}
+	return f
+}
+
 type FeatureNegater struct {
 	Feature Feature
 }
 
+// Not returns a feature which fires when f does not. Abstaining stumps
+// vote only where their feature fires, so a stump built from Not(f)
+// votes on exactly the examples a stump built from f abstains on.
+func Not(f Feature) Feature {
+	return &FeatureNegater{f}
+}
+
 func (f *FeatureNegater) String() string {
 	return fmt.Sprintf("not(%s)", f.Feature)
 }
 
+func (f *FeatureNegater) ID() string {
+	return fmt.Sprintf("not(%s)", f.Feature.ID())
+}
+
 func (f *FeatureNegater) Predict(e Example) float64 {
 	return -f.Feature.Predict(e)
 }
+
+type funcFeature struct {
+	FeatureID
+	test func(Example) bool
+}
+
+// FeatureFunc returns a feature named name which fires when test
+// returns true. Its ID is its name. Ensembles using it cannot be
+// saved, since there is no way to save test.
+func FeatureFunc(name string, test func(Example) bool) Feature {
+	return &funcFeature{FeatureID(name), test}
+}
+
+func (f *funcFeature) String() string {
+	return string(f.FeatureID)
+}
+
+func (f *funcFeature) Predict(e Example) float64 {
+	if f.test(e) {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
+
+// ThresholdFeature fires when a numeric attribute of an example is at
+// least Threshold or, if Below is set, when it is less than Threshold.
+type ThresholdFeature struct {
+	Name      string
+	Value     func(Example) float64
+	Threshold float64
+	Below     bool
+}
+
+func (f *ThresholdFeature) String() string {
+	if f.Below {
+		return fmt.Sprintf("%s<%g", f.Name, f.Threshold)
+	}
+	return fmt.Sprintf("%s>=%g", f.Name, f.Threshold)
+}
+
+func (f *ThresholdFeature) ID() string {
+	threshold := strconv.FormatFloat(f.Threshold, 'g', -1, 64)
+	if f.Below {
+		return fmt.Sprintf("threshold(%s<%s)", f.Name, threshold)
+	}
+	return fmt.Sprintf("threshold(%s>=%s)", f.Name, threshold)
+}
+
+func (f *ThresholdFeature) Predict(e Example) float64 {
+	if (f.Value(e) >= f.Threshold) != f.Below {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
+
+// ThresholdFeatures returns a ThresholdFeature for each midpoint
+// between consecutive distinct values of an attribute in examples.
+// Only features firing above the threshold are returned, since the
+// learners negate features as needed.
+func ThresholdFeatures(name string, value func(Example) float64, examples []Example) []Feature {
+	var values []float64
+	for _, example := range examples {
+		values = append(values, value(example))
+	}
+	sort.Float64s(values)
+
+	var features []Feature
+	for i := 1; i < len(values); i++ {
+		if values[i-1] != values[i] {
+			threshold := values[i-1] + (values[i]-values[i-1])/2
+			features = append(features, &ThresholdFeature{name, value, threshold, false})
+		}
+	}
+	return features
+}
+
+// PredictionFeature fires on the examples Classifier predicts are
+// positive, so the output of one model, such as a Model or an
+// AdaBoost, can be a feature for another. It is not registered with
+// RegisterClassifier, since the classifier it wraps may not be
+// encodable.
+type PredictionFeature struct {
+	Name       string
+	Classifier Classifier
+}
+
+func (f *PredictionFeature) String() string {
+	return "predicts*" + f.Name
+}
+
+func (f *PredictionFeature) ID() string {
+	return "prediction(" + f.Name + ")"
+}
+
+func (f *PredictionFeature) Predict(e Example) float64 {
+	if f.Classifier.Predict(e) > 0.0 {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
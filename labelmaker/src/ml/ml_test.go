@@ -1,9 +1,15 @@
 package ml
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -16,6 +22,18 @@ func TestSamplerUnitaryDistribution(t *testing.T) {
 	}
 }
 
+func TestSamplerToleratesDrift(t *testing.T) {
+	d := &Distribution{[]float64{0.5, 0.4999999}}
+	c := CumulativeDistributionOfDistribution(d)
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		x := c.Sample(r)
+		if x < 0 || 1 < x {
+			t.Errorf("sampling should produce index 0 or 1, was %d", x)
+		}
+	}
+}
+
 type reflectedFeature struct {
 	name  string
 	value string
@@ -25,6 +43,10 @@ func (r *reflectedFeature) String() string {
 	return fmt.Sprintf("%s*%v", r.name, r.value)
 }
 
+func (r *reflectedFeature) ID() string {
+	return r.String()
+}
+
 func (r *reflectedFeature) Predict(e Example) float64 {
 	val := reflect.ValueOf(e).MethodByName(r.name).Call(nil)[0].String()
 	if r.value == val {
@@ -73,3 +95,1929 @@ func TestDecisionStump(t *testing.T) {
 		t.Errorf("expected decision stump to split on Weight*heavy but split on %v", stump)
 	}
 }
+
+func TestDecisionStumpIsReproducible(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Color", "yellow"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+
+	x := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(7))).NewClassifier(dataset)
+	y := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(7))).NewClassifier(dataset)
+	if x.(Feature).String() != y.(Feature).String() {
+		t.Errorf("stumpers with the same inputs should agree but picked %v and %v", x, y)
+	}
+}
+
+func TestDecisionStumpFeatureSubset(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Color", "yellow"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+
+	all := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	full := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	full.SetFeatureSubset(len(features))
+	one := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	one.SetFeatureSubset(1)
+	for i := 0; i < 3; i++ {
+		x := all.NewClassifier(dataset).(Feature).String()
+		y := full.NewClassifier(dataset).(Feature).String()
+		if x != y {
+			t.Errorf("a subset of every feature should not change the stump but picked %s and %s", x, y)
+		}
+		if _, ok := one.NewClassifier(dataset).(*reflectedFeature); !ok {
+			t.Errorf("a subset of one feature should produce a stump on that feature")
+		}
+	}
+}
+
+func TestNewDecisionStumperChecked(t *testing.T) {
+	dataset := []Example{&datum{"red", "heavy", true}}
+	features := []Feature{&reflectedFeature{"Color", "red"}}
+	r := rand.New(rand.NewSource(0))
+	if _, err := NewDecisionStumperChecked(nil, dataset, r); err == nil {
+		t.Errorf("a stumper without features should fail")
+	}
+	if _, err := NewDecisionStumperChecked(features, nil, r); err == nil {
+		t.Errorf("a stumper without examples should fail")
+	}
+	if _, err := NewDecisionStumperChecked(features, dataset, r); err != nil {
+		t.Errorf("a stumper with features and examples should succeed but was %v", err)
+	}
+}
+
+func TestSplitCriterion(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+
+	if g := GiniCriterion(0.5, 0.0, 0.0, 0.5); 0.0 != g {
+		t.Errorf("a pure split should have Gini impurity 0 but was %f", g)
+	}
+	stumper := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	stumper.SetSplitCriterion(GiniCriterion)
+	if stump := stumper.NewClassifier(dataset); stump != features[1] {
+		t.Errorf("expected the purest split on Weight*heavy but split on %v", stump)
+	}
+	var calls int
+	stumper.SetSplitCriterion(func(firedPositive, firedNegative, unfiredPositive, unfiredNegative float64) float64 {
+		if sum := firedPositive + firedNegative + unfiredPositive + unfiredNegative; 1.0 != sum {
+			t.Errorf("expected weights summing to 1 but was %f", sum)
+		}
+		calls++
+		return 0.0
+	})
+	stumper.NewClassifier(dataset)
+	if calls == 0 {
+		t.Errorf("expected the stumper to use the split criterion")
+	}
+}
+
+func TestFeaturePriors(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", true},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+
+	stumper := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	if stump := stumper.NewClassifier(dataset); stump != features[0] && stump != features[1] {
+		t.Errorf("expected a single feature stump but was %v", stump)
+	}
+	if err := stumper.SetFeaturePriors(map[Feature]float64{&reflectedFeature{"Weight", "heavy"}: 2.0}); err != nil {
+		t.Fatal(err)
+	}
+	if stump := stumper.NewClassifier(dataset); stump != features[1] {
+		t.Errorf("expected the prior to favor Weight*heavy but split on %v", stump)
+	}
+	if err := stumper.SetFeaturePriors(map[Feature]float64{features[0]: 2.0}); err != nil {
+		t.Fatal(err)
+	}
+	if stump := stumper.NewClassifier(dataset); stump != features[0] {
+		t.Errorf("expected the prior to favor Color*red but split on %v", stump)
+	}
+	for _, prior := range []float64{0.0, -1.0, math.NaN(), math.Inf(1)} {
+		if err := stumper.SetFeaturePriors(map[Feature]float64{features[1]: prior}); err == nil {
+			t.Errorf("a prior of %f should be rejected", prior)
+		}
+	}
+	if err := stumper.SetFeaturePriors(map[Feature]float64{&reflectedFeature{"Weight", "light"}: 2.0}); err == nil {
+		t.Errorf("a prior for a feature the stumper does not have should be rejected")
+	}
+	if stump := stumper.NewClassifier(dataset); stump != features[0] {
+		t.Errorf("rejected priors should leave the priors unchanged but split on %v", stump)
+	}
+}
+
+// sliceExample is a TokenExample value which cannot be a map key.
+type sliceExample struct {
+	tokens []string
+	label  Label
+}
+
+func (e sliceExample) Label() Label {
+	return e.label
+}
+
+func (e sliceExample) HasToken(token string) bool {
+	for _, t := range e.tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDecisionStumpUnhashableExamples(t *testing.T) {
+	dataset := []Example{
+		sliceExample{[]string{"crash", "tab"}, true},
+		sliceExample{[]string{"tab"}, false},
+		sliceExample{[]string{"font"}, false},
+		sliceExample{[]string{"crash"}, true},
+	}
+	features := TokenFeatures([]string{"crash", "tab", "font"})
+	r := rand.New(rand.NewSource(42))
+	stumper := NewDecisionStumper(features, dataset[:2], r)
+	a := NewAdaBoost(dataset[:2], stumper, r)
+	a.Train(1, 2)
+	a.AddExamples(dataset[2:])
+	a.Train(2, 4)
+	if a.ClassifierCount() != 3 {
+		t.Errorf("expected to train 3 rounds on unhashable examples but trained %d", a.ClassifierCount())
+	}
+}
+
+func TestDecisionStumpCaching(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Color", "yellow"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+
+	cached := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	uncached := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(42)))
+	uncached.SetCaching(false)
+	for i := 0; i < 3; i++ {
+		x := cached.NewClassifier(dataset).(Feature).String()
+		y := uncached.NewClassifier(dataset).(Feature).String()
+		if x != y {
+			t.Errorf("cached and uncached stumpers should agree but picked %s and %s", x, y)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	d := &Distribution{[]float64{1.0, 3.0}}
+	if err := d.Normalize(); err != nil {
+		t.Errorf("normalizing should succeed but was %v", err)
+	}
+	if 0.25 != d.P[0] || 0.75 != d.P[1] {
+		t.Errorf("expected normalized distribution [0.25 0.75] but was %v", d.P)
+	}
+}
+
+func TestNormalizeZeroSum(t *testing.T) {
+	d := &Distribution{[]float64{0.0, 0.0}}
+	if err := d.Normalize(); err == nil {
+		t.Errorf("normalizing a zero distribution should fail")
+	}
+	if 0.0 != d.P[0] || 0.0 != d.P[1] {
+		t.Errorf("a zero distribution should be left untouched but was %v", d.P)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	d := &Distribution{[]float64{0.5, 0.5, 0.0}}
+	h := d.Entropy()
+	if math.Abs(h-math.Log(2)) > 1e-12 {
+		t.Errorf("expected entropy log(2) but was %f", h)
+	}
+}
+
+func TestArgmax(t *testing.T) {
+	d := &Distribution{[]float64{0.1, 0.4, 0.1, 0.4}}
+	if 1 != d.Argmax() || 0.4 != d.Max() {
+		t.Errorf("expected the first of the tied items, 1 with 0.4, but was %d with %f", d.Argmax(), d.Max())
+	}
+	empty := &Distribution{}
+	if -1 != empty.Argmax() || 0.0 != empty.Max() {
+		t.Errorf("expected -1 and 0.0 for an empty distribution but was %d and %f", empty.Argmax(), empty.Max())
+	}
+}
+
+func TestGini(t *testing.T) {
+	if g := UniformDistribution(4).Gini(); math.Abs(g-0.75) > 1e-12 {
+		t.Errorf("expected the Gini impurity of a uniform distribution over 4 items to be 0.75 but was %f", g)
+	}
+	if g := (&Distribution{[]float64{0.0, 1.0, 0.0}}).Gini(); 0.0 != g {
+		t.Errorf("expected the Gini impurity of a point mass to be 0 but was %f", g)
+	}
+}
+
+func TestScale(t *testing.T) {
+	d := &Distribution{[]float64{0.25, 0.75}}
+	if err := d.Scale(0.5); err != nil || !reflect.DeepEqual([]float64{0.125, 0.375}, d.P) {
+		t.Errorf("expected scaling by 0.5 to give [0.125 0.375] but was %v, %v", d.P, err)
+	}
+	if err := d.Normalize(); err != nil || !reflect.DeepEqual([]float64{0.25, 0.75}, d.P) {
+		t.Errorf("expected normalizing to restore [0.25 0.75] but was %v, %v", d.P, err)
+	}
+	for _, factor := range []float64{0.0, -1.0, math.NaN()} {
+		if err := d.Scale(factor); err == nil {
+			t.Errorf("scaling by %f should fail", factor)
+		}
+	}
+	if !reflect.DeepEqual([]float64{0.25, 0.75}, d.P) {
+		t.Errorf("failing to scale should leave the distribution untouched but was %v", d.P)
+	}
+}
+
+func TestKLDivergence(t *testing.T) {
+	p := &Distribution{[]float64{0.5, 0.5}}
+	q := &Distribution{[]float64{0.25, 0.75}}
+	d, err := p.KLDivergence(q)
+	if err != nil {
+		t.Errorf("KL divergence should succeed but was %v", err)
+	}
+	e := 0.5*math.Log(2) + 0.5*math.Log(2.0/3.0)
+	if math.Abs(d-e) > 1e-12 {
+		t.Errorf("expected KL divergence %f but was %f", e, d)
+	}
+	if _, err := p.KLDivergence(UniformDistribution(3)); err == nil {
+		t.Errorf("KL divergence of different length distributions should fail")
+	}
+}
+
+func TestCrossEntropy(t *testing.T) {
+	p := &Distribution{[]float64{0.25, 0.75}}
+	h, err := p.CrossEntropy(&Distribution{[]float64{0.0, 1.0}})
+	if err != nil || math.Abs(h+math.Log(0.75)) > 1e-12 {
+		t.Errorf("expected cross-entropy %f but was %f, %v", -math.Log(0.75), h, err)
+	}
+	zero := &Distribution{[]float64{0.0, 1.0}}
+	if h, _ := zero.CrossEntropy(p); !math.IsInf(h, 1) {
+		t.Errorf("cross-entropy where p is zero should be +Inf but was %f", h)
+	}
+	if _, err := p.CrossEntropy(UniformDistribution(3)); err == nil {
+		t.Errorf("cross-entropy of different length distributions should fail")
+	}
+}
+
+func TestClone(t *testing.T) {
+	d := &Distribution{[]float64{0.25, 0.75}}
+	c := d.Clone()
+	c.P[0] = 1.0
+	if 0.25 != d.P[0] {
+		t.Errorf("changing a clone should not change the original but was %v", d.P)
+	}
+}
+
+func TestMeanVariance(t *testing.T) {
+	d := &Distribution{[]float64{0.25, 0.0, 0.75}}
+	if 1.5 != d.Mean() || 0.75 != d.Variance() {
+		t.Errorf("expected mean 1.5 and variance 0.75 but was %f and %f", d.Mean(), d.Variance())
+	}
+}
+
+func TestSampleN(t *testing.T) {
+	d := &Distribution{[]float64{0.2, 0.0, 0.3, 0.5}}
+	r := rand.New(rand.NewSource(0))
+	xs, err := d.SampleN(r, 3)
+	if err != nil {
+		t.Errorf("sampling 3 items should succeed but was %v", err)
+	}
+	seen := make(map[int]bool)
+	for _, x := range xs {
+		if x == 1 || seen[x] {
+			t.Errorf("expected distinct samples of non-zero items but was %v", xs)
+		}
+		seen[x] = true
+	}
+	if _, err := d.SampleN(r, 4); err == nil {
+		t.Errorf("sampling more items than have non-zero probability should fail")
+	}
+}
+
+func TestNewDistributionFromCounts(t *testing.T) {
+	counts := []float64{2.0, 0.0, 6.0}
+	d, err := NewDistributionFromCounts(counts)
+	if err != nil {
+		t.Errorf("building a distribution from counts should succeed but was %v", err)
+	}
+	if !reflect.DeepEqual([]float64{0.25, 0.0, 0.75}, d.P) {
+		t.Errorf("expected distribution [0.25 0 0.75] but was %v", d.P)
+	}
+	if 2.0 != counts[0] {
+		t.Errorf("building a distribution should not modify the counts")
+	}
+	if _, err := NewDistributionFromCounts([]float64{0.0, 0.0}); err == nil {
+		t.Errorf("building a distribution from zero counts should fail")
+	}
+	if _, err := NewDistributionFromCounts([]float64{1.0, -1.0}); err == nil {
+		t.Errorf("building a distribution from negative counts should fail")
+	}
+}
+
+func TestDistributionJson(t *testing.T) {
+	d := &Distribution{[]float64{0.25, 0.75}}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Errorf("marshaling a distribution should succeed but was %v", err)
+	}
+	if `{"p":[0.25,0.75]}` != string(b) {
+		t.Errorf("unexpected JSON for distribution: %s", b)
+	}
+	var e Distribution
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Errorf("unmarshaling a distribution should succeed but was %v", err)
+	}
+	if !reflect.DeepEqual(d.P, e.P) {
+		t.Errorf("expected distribution %v but was %v", d.P, e.P)
+	}
+	for _, s := range []string{`{"p":[]}`, `{"p":[0.5,-0.5]}`} {
+		if err := json.Unmarshal([]byte(s), &e); err == nil {
+			t.Errorf("unmarshaling %s should fail", s)
+		}
+	}
+}
+
+func TestTopK(t *testing.T) {
+	d := &Distribution{[]float64{0.1, 0.3, 0.2, 0.3, 0.1}}
+	if top := d.TopK(3); !reflect.DeepEqual([]int{1, 3, 2}, top) {
+		t.Errorf("expected top 3 to be [1 3 2] but was %v", top)
+	}
+	if top := d.TopK(10); !reflect.DeepEqual([]int{1, 3, 2, 0, 4}, top) {
+		t.Errorf("expected top 10 to be [1 3 2 0 4] but was %v", top)
+	}
+}
+
+func TestAliasSampler(t *testing.T) {
+	d := &Distribution{[]float64{0.1, 0.0, 0.6, 0.3}}
+	s, err := d.NewAliasSampler()
+	if err != nil {
+		t.Fatalf("building a sampler should succeed but was %v", err)
+	}
+	r := rand.New(rand.NewSource(0))
+	counts := make([]float64, len(d.P))
+	n := 100000
+	for i := 0; i < n; i++ {
+		counts[s.Sample(r)]++
+	}
+	for i, p := range d.P {
+		if math.Abs(counts[i]/float64(n)-p) > 0.01 {
+			t.Errorf("item %d was sampled with frequency %f, expected %f", i, counts[i]/float64(n), p)
+		}
+	}
+	for _, p := range [][]float64{{}, {0.0, 0.0}} {
+		if _, err := (&Distribution{p}).NewAliasSampler(); err == nil {
+			t.Errorf("building a sampler for %v should fail", p)
+		}
+	}
+}
+
+func benchmarkDistribution() *Distribution {
+	r := rand.New(rand.NewSource(0))
+	counts := make([]float64, 10000)
+	for i := range counts {
+		counts[i] = r.Float64()
+	}
+	d, _ := NewDistributionFromCounts(counts)
+	return d
+}
+
+func BenchmarkCumulativeSample(b *testing.B) {
+	d := benchmarkDistribution()
+	r := rand.New(rand.NewSource(0))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CumulativeDistributionOfDistribution(d).Sample(r)
+	}
+}
+
+func BenchmarkAliasSample(b *testing.B) {
+	d := benchmarkDistribution()
+	r := rand.New(rand.NewSource(0))
+	s, _ := d.NewAliasSampler()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Sample(r)
+	}
+}
+
+type colorFeature struct {
+	Color string
+}
+
+func (f *colorFeature) String() string {
+	return "color*" + f.Color
+}
+
+func (f *colorFeature) ID() string {
+	return f.String()
+}
+
+func (f *colorFeature) Predict(e Example) float64 {
+	if e.(interface{ Color() string }).Color() == f.Color {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
+
+func init() {
+	RegisterClassifier("test-color", &colorFeature{})
+}
+
+func TestSaveLoadAdaBoost(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	a := &AdaBoost{
+		H: []Classifier{
+			&andFeature{red, yellow},
+			&FeatureNegater{red},
+			&FeatureNode{yellow, &LeafNode{true}, &LeafNode{false}},
+		},
+		A:           []float64{0.5, 0.25, 1.0},
+		Eta:         0.1,
+		TrainErrors: []float64{0.5, 0.25, 0.25},
+	}
+	var b bytes.Buffer
+	if err := a.Save(&b); err != nil {
+		t.Fatalf("saving should succeed but was %v", err)
+	}
+	loaded, err := LoadAdaBoost(&b)
+	if err != nil {
+		t.Fatalf("loading should succeed but was %v", err)
+	}
+	for _, e := range []Example{&datum{"red", "heavy", true}, &datum{"yellow", "light", false}} {
+		if a.Predict(e) != loaded.Predict(e) {
+			t.Errorf("loaded model predicted %f but expected %f", loaded.Predict(e), a.Predict(e))
+		}
+	}
+	if !reflect.DeepEqual(a.TrainErrors, loaded.TrainErrors) {
+		t.Errorf("expected training errors %v but loaded %v", a.TrainErrors, loaded.TrainErrors)
+	}
+	if 0.1 != loaded.Eta {
+		t.Errorf("expected Eta 0.1 but loaded %f", loaded.Eta)
+	}
+	old, err := LoadAdaBoost(strings.NewReader(`{"h":[],"a":[]}`))
+	if err != nil || 1.0 != old.Eta {
+		t.Errorf("a model saved without Eta should load with Eta 1.0 but was %v, %v", old, err)
+	}
+}
+
+func TestLoadAdaBoostRejectsMissingClassifiers(t *testing.T) {
+	for _, saved := range []string{
+		`{"h":[null],"a":[1]}`,
+		`{"h":[{"type":"and","value":[null,null]}],"a":[1]}`,
+	} {
+		if _, err := LoadAdaBoost(strings.NewReader(saved)); err == nil {
+			t.Errorf("loading %s should fail", saved)
+		}
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{0.5}}
+	m := a.Freeze()
+	a.H = append(a.H, &FeatureNegater{red})
+	a.A = append(a.A, 1.0)
+	e := &datum{"red", "heavy", true}
+	if 0.5 != m.Predict(e) || !bool(m.PredictLabel(e)) || a.PredictProba(e) == m.PredictProba(e) {
+		t.Errorf("a frozen model should not change with the ensemble but predicted %f", m.Predict(e))
+	}
+	var b bytes.Buffer
+	a.Save(&b)
+	loaded, err := LoadModel(&b)
+	if err != nil || a.Predict(e) != loaded.Predict(e) {
+		t.Errorf("loaded model predicted %f but expected %f, %v", loaded.Predict(e), a.Predict(e), err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	red := &colorFeature{"red"}
+	dataset := []Example{&datum{"red", "heavy", true}, &datum{"red", "light", false}}
+	a := NewAdaBoost(dataset, nil, rand.New(rand.NewSource(0)))
+	a.H, a.A = []Classifier{&andFeature{red, red}}, []float64{0.5}
+	if err := a.Validate(); err != nil {
+		t.Errorf("a valid ensemble should validate but was %v", err)
+	}
+	invalid := []func(a *AdaBoost){
+		func(a *AdaBoost) { a.A = nil },
+		func(a *AdaBoost) { a.A[0] = math.NaN() },
+		func(a *AdaBoost) { a.H[0] = &FeatureNegater{&andFeature{red, nil}} },
+		func(a *AdaBoost) { a.D.P[0] = 0.75 },
+		func(a *AdaBoost) { a.Examples = dataset[:1] },
+	}
+	for i, corrupt := range invalid {
+		b := NewAdaBoost(dataset, nil, rand.New(rand.NewSource(0)))
+		b.H, b.A = []Classifier{red}, []float64{0.5}
+		corrupt(b)
+		if err := b.Validate(); err == nil {
+			t.Errorf("corruption %d should fail validation", i)
+		}
+	}
+}
+
+func TestLossGradient(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{0.5}}
+	g := a.LossGradient([]Example{&datum{"red", "heavy", true}, &datum{"red", "light", false}})
+	if !reflect.DeepEqual([]float64{-math.Exp(-0.5), math.Exp(0.5)}, g) {
+		t.Errorf("unexpected gradient %v", g)
+	}
+}
+
+func TestSelectionHistory(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{&colorFeature{"red"}, &colorFeature{"yellow"}}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	a.Train(2, 4)
+	history := a.SelectionHistory()
+	if len(history) != 2 || history[1].Stump != a.H[1].(Feature).String() {
+		t.Errorf("expected a selection for each round but was %v", history)
+	}
+	if h := (&AdaBoost{Learner: NewDecisionTreeBuilder(features, 1)}).SelectionHistory(); h != nil {
+		t.Errorf("a learner without a history should have none but had %v", h)
+	}
+}
+
+func TestTrainWithCheckpoints(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{&colorFeature{"red"}, &colorFeature{"yellow"}}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	checkpoints := make(map[int]*bytes.Buffer)
+	err := a.TrainWithCheckpoints(5, 4, 2, func(round int) io.Writer {
+		checkpoints[round] = &bytes.Buffer{}
+		return checkpoints[round]
+	})
+	if err != nil || len(checkpoints) != 2 || checkpoints[2] == nil || checkpoints[4] == nil {
+		t.Fatalf("expected checkpoints after rounds 2 and 4 but was %v, %v", checkpoints, err)
+	}
+	loaded, err := LoadAdaBoost(checkpoints[4])
+	if err != nil || loaded.ClassifierCount() != 4 {
+		t.Errorf("expected the checkpoint to have 4 classifiers but was %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{&colorFeature{"red"}, &colorFeature{"yellow"}}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	a.Eta = 0.5
+	a.Train(3, 4)
+	var b bytes.Buffer
+	a.Save(&b)
+	loaded, err := LoadAdaBoost(&b)
+	if err != nil {
+		t.Fatalf("loading should succeed but was %v", err)
+	}
+	loaded.Resume(dataset, NewDecisionStumper(features, dataset, r), r)
+	if 0.5 != loaded.Eta {
+		t.Errorf("expected a resumed model to keep Eta 0.5 but was %f", loaded.Eta)
+	}
+	if !loaded.D.ApproxEqual(a.D, 1e-9) {
+		t.Errorf("expected resumed weights %v but was %v", a.D.P, loaded.D.P)
+	}
+	loaded.Train(1, 4)
+	if loaded.ClassifierCount() != 4 {
+		t.Errorf("expected a resumed model to keep training but had %d classifiers", loaded.ClassifierCount())
+	}
+}
+
+func TestPrepend(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, nil, r)
+	a.H, a.A = []Classifier{yellow}, []float64{0.25}
+	if err := a.Prepend([]Classifier{red}, []float64{0.5}); err != nil {
+		t.Fatalf("prepending should succeed but was %v", err)
+	}
+	if !reflect.DeepEqual([]Classifier{red, yellow}, a.H) || !reflect.DeepEqual([]float64{0.5, 0.25}, a.A) {
+		t.Errorf("expected the ensemble [red yellow] but was %v, %v", a.H, a.A)
+	}
+	wrong, right := math.Exp(0.5), math.Exp(-0.5)
+	sum := 2*wrong + 2*right
+	expected := &Distribution{[]float64{right / sum, wrong / sum, right / sum, wrong / sum}}
+	if !a.D.ApproxEqual(expected, 1e-12) {
+		t.Errorf("expected weights %v but was %v", expected.P, a.D.P)
+	}
+	if err := a.Prepend([]Classifier{red}, nil); err == nil {
+		t.Errorf("prepending without weights should fail")
+	}
+}
+
+func TestTrainUntilConverged(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	before := a.Evaluate(dataset)
+	rounds, err := a.TrainUntilConverged(dataset, 2, 10, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rounds > 10 {
+		t.Errorf("should run at most 10 rounds but ran %d", rounds)
+	}
+	if len(a.H) > rounds || len(a.H) != len(a.A) {
+		t.Errorf("ensemble should be a prefix of %d rounds but had %d classifiers and %d weights", rounds, len(a.H), len(a.A))
+	}
+	if after := a.Evaluate(dataset); after > before {
+		t.Errorf("the kept ensemble should be no worse than the untrained one, but error was %f and is now %f", before, after)
+	}
+}
+
+func TestTrainContextStopsWhenCanceled(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{
+		&colorFeature{"red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.OnRound = func(round int, h Classifier, trainError float64) {
+		if round == 1 {
+			cancel()
+		}
+	}
+	rounds, err := a.TrainContext(ctx, 10, 4)
+	if 2 != rounds || context.Canceled != err {
+		t.Errorf("expected to stop after 2 rounds with %v but ran %d with %v", context.Canceled, rounds, err)
+	}
+	if 2 != a.ClassifierCount() {
+		t.Errorf("expected to keep the 2 classifiers trained but had %d", a.ClassifierCount())
+	}
+}
+
+func TestTrainUntilConvergedRejectsNoPatience(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", false},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper([]Feature{&colorFeature{"red"}}, dataset, r), r)
+	if _, err := a.TrainUntilConverged(dataset, 0, 10, 2); err == nil {
+		t.Errorf("training with no patience should fail")
+	}
+	if 0 != a.ClassifierCount() {
+		t.Errorf("training with no patience should not run any rounds but ran %d", a.ClassifierCount())
+	}
+}
+
+func TestRoundKeepsDistributionNormalized(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	for i := 0; i < 5; i++ {
+		a.Round(4)
+		sum := 0.0
+		for _, p := range a.D.P {
+			sum += p
+		}
+		if math.Abs(sum-1.0) > 1e-9 {
+			t.Errorf("after round %d the distribution should sum to 1.0 but was %f", i, sum)
+		}
+	}
+}
+
+func TestTrainErrorsAfterEditingEnsemble(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+		&datum{"yellow", "heavy", true},
+	}
+	features := []Feature{
+		&colorFeature{"red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	check := func(when string) {
+		if err := a.Round(5); err != nil {
+			t.Fatal(err)
+		}
+		if e := a.ConfusionMatrix(dataset).ErrorRate(); a.TrainErrors[len(a.TrainErrors)-1] != e {
+			t.Errorf("after %s expected training error %f but recorded %f", when, e, a.TrainErrors[len(a.TrainErrors)-1])
+		}
+	}
+	if err := a.Train(3, 5); err != nil {
+		t.Fatal(err)
+	}
+	a.Truncate(1)
+	check("truncating")
+	a.Prepend([]Classifier{&FeatureNegater{features[0]}}, []float64{2.0})
+	check("prepending")
+	a.AddExamples([]Example{&datum{"red", "heavy", false}})
+	dataset = a.Examples
+	check("adding examples")
+	for i := range a.A {
+		a.A[i] = -a.A[i]
+	}
+	a.ResetCache()
+	check("editing the weights")
+}
+
+// constantLearner always returns the same classifier.
+type constantLearner struct {
+	c Classifier
+}
+
+func (l *constantLearner) NewClassifier([]Example) Classifier {
+	return l.c
+}
+
+func TestEtaShrinksEachRound(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", false},
+	}
+	red := &colorFeature{"red"}
+	round := func(eta float64) *AdaBoost {
+		r := rand.New(rand.NewSource(42))
+		a := NewAdaBoost(dataset, &constantLearner{red}, r)
+		a.Eta = eta
+		if err := a.Round(4); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	full, half := round(1.0), round(0.5)
+	if math.Abs(half.A[0]-0.5*full.A[0]) > 1e-12 {
+		t.Errorf("expected Eta 0.5 to halve the weight %f but was %f", full.A[0], half.A[0])
+	}
+	if e := dataset[0]; math.Abs(half.Predict(e)-0.5*full.Predict(e)) > 1e-12 {
+		t.Errorf("expected Eta 0.5 to halve the prediction %f but was %f", full.Predict(e), half.Predict(e))
+	}
+	// red misclassifies dataset[1]; the log-ratio of its weight to
+	// that of a correctly classified example is 2 a_t.
+	ratio := func(a *AdaBoost) float64 {
+		return math.Log(a.D.P[1] / a.D.P[0])
+	}
+	if math.Abs(ratio(full)-2*full.A[0]) > 1e-12 || math.Abs(ratio(half)-0.5*ratio(full)) > 1e-12 {
+		t.Errorf("expected Eta 0.5 to halve the log-ratio of the weights %f but was %f", ratio(full), ratio(half))
+	}
+}
+
+func TestRoundRejectsClassifierWhichAlwaysAbstains(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", false},
+	}
+	never := NewAbstainingStump(&colorFeature{"blue"}, 1.0)
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, &constantLearner{never}, r)
+	if err := a.Round(2); err == nil {
+		t.Errorf("a round with a classifier which always abstains should fail")
+	}
+	if len(a.H) != 0 || len(a.A) != 0 {
+		t.Errorf("a failed round should not change the ensemble but it has %d classifiers", len(a.H))
+	}
+	for i, p := range a.D.P {
+		if p != 0.5 {
+			t.Errorf("a failed round should not change the distribution but example %d has weight %f", i, p)
+		}
+	}
+}
+
+func TestThresholdFeatures(t *testing.T) {
+	value := func(e Example) float64 {
+		return float64(len(e.(*datum).color))
+	}
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", false},
+		&datum{"red", "light", false},
+		&datum{"blue", "light", true},
+	}
+	fs := ThresholdFeatures("len", value, dataset)
+	if 2 != len(fs) {
+		t.Fatalf("expected 2 thresholds but was %v", fs)
+	}
+	if "len>=3.5" != fs[0].String() || "len>=5" != fs[1].String() {
+		t.Errorf("expected thresholds len>=3.5 and len>=5 but was %v", fs)
+	}
+	if 1.0 != fs[0].Predict(dataset[1]) || -1.0 != fs[0].Predict(dataset[0]) {
+		t.Errorf("len>=3.5 should fire on yellow and not on red")
+	}
+}
+
+func TestAbstainingDecisionStump(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", true},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "light"},
+	}
+	r := rand.New(rand.NewSource(42))
+	stumper := NewAbstainingDecisionStumper(features, dataset, r)
+	stump := stumper.NewClassifier(dataset)
+	if 1.0 != stump.Predict(dataset[0]) || 1.0 != stump.Predict(dataset[1]) {
+		t.Errorf("expected %v to vote for red examples", stump)
+	}
+	if 0.0 != stump.Predict(dataset[2]) || 0.0 != stump.Predict(dataset[3]) {
+		t.Errorf("expected %v to abstain on yellow examples", stump)
+	}
+}
+
+func TestConfusionMatrix(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	test := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+		&datum{"yellow", "heavy", true},
+	}
+	m := a.ConfusionMatrix(test)
+	if (ConfusionMatrix{1, 1, 2, 1}) != m {
+		t.Errorf("expected confusion matrix {1 1 2 1} but was %v", m)
+	}
+}
+
+func TestConfusionMatrixOf(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	test := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+	}
+	if m := a.ConfusionMatrixOf(SliceSource(test)); a.ConfusionMatrix(test) != m {
+		t.Errorf("streaming evaluation should match ConfusionMatrix but was %v", m)
+	}
+	if es := Collect(SliceSource(test)); !reflect.DeepEqual(test, es) {
+		t.Errorf("expected to collect %v but was %v", test, es)
+	}
+}
+
+func TestDecisionStumperFromSource(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", true},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "light"},
+	}
+	streamed, es := NewDecisionStumperFromSource(features, SliceSource(dataset), rand.New(rand.NewSource(7)))
+	if !reflect.DeepEqual(dataset, es) {
+		t.Errorf("expected to read %v but was %v", dataset, es)
+	}
+	if len(streamed.fires) != len(features) || len(streamed.fires[0]) != len(dataset) {
+		t.Errorf("expected the cache to be filled while reading")
+	}
+	stumper := NewDecisionStumper(features, dataset, rand.New(rand.NewSource(7)))
+	if x, y := streamed.NewClassifier(dataset), stumper.NewClassifier(dataset); x != y {
+		t.Errorf("a stumper reading a source should pick the same stump, %v, but picked %v", y, x)
+	}
+}
+
+type identifiedDatum struct {
+	datum
+	id string
+}
+
+func (d *identifiedDatum) ID() string {
+	return d.id
+}
+
+func TestReport(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{0.5}}
+	test := []Example{
+		&identifiedDatum{datum{"red", "heavy", true}, "bug-1"},
+		&datum{"yellow", "light", false},
+	}
+	expected := map[string]float64{"bug-1": 0.5, "1": -0.5}
+	if report := a.Report(test); !reflect.DeepEqual(expected, report) {
+		t.Errorf("expected report %v but was %v", expected, report)
+	}
+}
+
+func TestPrecisionRecallAtK(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	test := []Example{
+		&datum{"yellow", "light", false},
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", true},
+	}
+	if p, r := a.PrecisionAtK(test, 1), a.RecallAtK(test, 1); 1.0 != p || 0.5 != r {
+		t.Errorf("expected precision@1 1.0 and recall@1 0.5 but was %f and %f", p, r)
+	}
+	if p, r := a.PrecisionAtK(test, 5), a.RecallAtK(test, 5); 2.0/3.0 != p || 1.0 != r {
+		t.Errorf("expected precision@5 0.67 and recall@5 1.0 but was %f and %f", p, r)
+	}
+}
+
+func TestTuneThreshold(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	val := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", true},
+		&datum{"yellow", "heavy", true},
+		&datum{"yellow", "light", false},
+	}
+	threshold := a.TuneThreshold(val)
+	if -2.0 != threshold {
+		t.Errorf("expected to predict everything positive below -2 but threshold was %f", threshold)
+	}
+	if !bool(a.PredictWithThreshold(val[1], threshold)) || bool(a.PredictWithThreshold(val[1], 0.0)) {
+		t.Errorf("expected the threshold to change the prediction for %v", val[1])
+	}
+	if threshold := a.TuneThreshold(val[:1]); 0.0 != threshold {
+		t.Errorf("expected the default threshold when it is best but was %f", threshold)
+	}
+}
+
+func TestEvaluateAll(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	test := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+		&datum{"yellow", "heavy", true},
+	}
+	m := a.EvaluateAll(test)
+	if 5 != m.Examples || (ConfusionMatrix{1, 1, 2, 1}) != m.Counts || 0.6 != m.ErrorRate || 0.4 != m.MacroF1 {
+		t.Errorf("unexpected metrics %+v", m)
+	}
+	if (ClassMetrics{0.5, 1.0 / 3.0, 0.4}) != m.Positive {
+		t.Errorf("unexpected positive class metrics %+v", m.Positive)
+	}
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("metrics should encode as JSON but was %v", err)
+	}
+	var decoded struct {
+		Counts map[string]int `json:"counts"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(map[string]int{"tp": 1, "fp": 1, "fn": 2, "tn": 1}, decoded.Counts) {
+		t.Errorf("expected counts to encode as {tp fp fn tn} but was %s", encoded)
+	}
+}
+
+func TestConfusionMatrixMetrics(t *testing.T) {
+	m := ConfusionMatrix{TP: 1, FP: 1, FN: 2, TN: 1}
+	if p := m.Precision(); 0.5 != p[true] || 1.0/3.0 != p[false] {
+		t.Errorf("expected precision {true: 0.5, false: 0.33} but was %v", p)
+	}
+	if r := m.Recall(); 1.0/3.0 != r[true] || 0.5 != r[false] {
+		t.Errorf("expected recall {true: 0.33, false: 0.5} but was %v", r)
+	}
+	if f := m.F1(); 0.4 != f[true] || 0.4 != f[false] {
+		t.Errorf("expected F1 {true: 0.4, false: 0.4} but was %v", f)
+	}
+	if 0.4 != MacroAverage(m.F1()) || 0.4 != m.Accuracy() {
+		t.Errorf("expected macro F1 and accuracy to be 0.4")
+	}
+	if p := (ConfusionMatrix{FN: 1, TN: 1}).Precision(); 0.0 != p[true] {
+		t.Errorf("precision of a class which was never predicted should be 0.0 but was %f", p[true])
+	}
+}
+
+func TestROC(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	test := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+		&datum{"yellow", "heavy", true},
+	}
+	tpr, fpr := a.ROC(test)
+	if !reflect.DeepEqual([]float64{0.0, 1.0 / 3.0, 1.0}, tpr) || !reflect.DeepEqual([]float64{0.0, 0.5, 1.0}, fpr) {
+		t.Errorf("unexpected ROC curve %v, %v", tpr, fpr)
+	}
+	if auc := AUC(tpr, fpr); math.Abs(auc-5.0/12.0) > 1e-9 {
+		t.Errorf("expected AUC 5/12 but was %f", auc)
+	}
+	if tpr, fpr := a.ROC(test[3:]); tpr != nil || fpr != nil || !math.IsNaN(AUC(tpr, fpr)) {
+		t.Errorf("ROC of a test set without negatives should be nil but was %v, %v", tpr, fpr)
+	}
+}
+
+func TestSampleFrom(t *testing.T) {
+	c := CumulativeDistributionOfDistribution(&Distribution{[]float64{0.25, 0.0, 0.75}})
+	for u, expected := range map[float64]int{0.0: 0, 0.1: 0, 0.25: 2, 0.26: 2, 0.99: 2} {
+		if x := c.SampleFrom(u); x != expected {
+			t.Errorf("sampling with draw %f should produce index %d but was %d", u, expected, x)
+		}
+	}
+	leading := CumulativeDistributionOfDistribution(&Distribution{[]float64{0.0, 0.0, 1.0}})
+	if x := leading.SampleFrom(0.0); 2 != x {
+		t.Errorf("sampling should skip items with no mass but produced index %d", x)
+	}
+}
+
+func TestLabelStats(t *testing.T) {
+	dataset := []Example{&datum{"red", "heavy", true}, &datum{"yellow", "light", true}}
+	if stats := LabelStats(dataset); !reflect.DeepEqual(map[Label]int{true: 2, false: 0}, stats) {
+		t.Errorf("expected 2 positive and 0 negative examples but was %v", stats)
+	}
+}
+
+func TestBootstrapSample(t *testing.T) {
+	dataset := []Example{&datum{"red", "heavy", true}, &datum{"yellow", "light", false}}
+	r := rand.New(rand.NewSource(0))
+	if xs := BootstrapSample(dataset, r); len(xs) != 2 {
+		t.Errorf("expected a sample of 2 examples but was %v", xs)
+	}
+	xs, err := WeightedBootstrapSample(dataset, &Distribution{[]float64{0.0, 1.0}}, r)
+	if err != nil || !reflect.DeepEqual([]Example{dataset[1], dataset[1]}, xs) {
+		t.Errorf("expected to sample only the yellow example but was %v, %v", xs, err)
+	}
+	if _, err := WeightedBootstrapSample(dataset, UniformDistribution(3), r); err == nil {
+		t.Errorf("sampling with the wrong length distribution should fail")
+	}
+	if _, err := WeightedBootstrapSample(dataset, &Distribution{[]float64{0.0, 0.0}}, r); err == nil {
+		t.Errorf("sampling with weights which sum to zero should fail")
+	}
+}
+
+func TestSampleChecked(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for _, p := range [][]float64{{}, {0.5, -0.5, 1.0}, {0.2, 0.3}} {
+		c := CumulativeDistributionOfDistribution(&Distribution{p})
+		if _, err := c.SampleChecked(r); err == nil {
+			t.Errorf("sampling %v should fail", p)
+		}
+	}
+	c := CumulativeDistributionOfDistribution(&Distribution{[]float64{0.0, 1.0}})
+	if x, err := c.SampleChecked(r); err != nil || x != 1 {
+		t.Errorf("sampling [0 1] should produce index 1 but was %d, %v", x, err)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	c := &abstainingStump{Not(And(red, Or(yellow, red))), 1.0}
+	if fs := Features(c); !reflect.DeepEqual([]Feature{red, yellow, red}, fs) {
+		t.Errorf("expected features [red yellow red] but was %v", fs)
+	}
+	if fs := Features(&LeafNode{true}); len(fs) != 0 {
+		t.Errorf("a leaf should have no features but had %v", fs)
+	}
+}
+
+func TestFeatureImportance(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	a := &AdaBoost{
+		H: []Classifier{
+			&andFeature{red, yellow},
+			&FeatureNegater{red},
+			&FeatureNode{yellow, &LeafNode{true}, &LeafNode{false}},
+		},
+		A: []float64{0.5, 0.25, 1.0},
+	}
+	importance := a.FeatureImportance()
+	if 2 != len(importance) || 0.75 != importance["color*red"] || 1.5 != importance["color*yellow"] {
+		t.Errorf("expected importance {red: 0.75, yellow: 1.5} but was %v", importance)
+	}
+}
+
+func TestFeatureImportanceCountsEqualFeaturesTogether(t *testing.T) {
+	a := &AdaBoost{
+		H: []Classifier{
+			&andFeature{&colorFeature{"red"}, &colorFeature{"red"}},
+			&colorFeature{"red"},
+		},
+		A: []float64{0.5, 0.25},
+	}
+	if importance := a.FeatureImportance(); 1 != len(importance) || 0.75 != importance["color*red"] {
+		t.Errorf("expected importance {red: 0.75} but was %v", importance)
+	}
+}
+
+func TestFeatureImportanceSurvivesSave(t *testing.T) {
+	dataset := []Example{
+		NewSparseExample([]string{"crash", "gpu"}, true),
+		NewSparseExample([]string{"crash", "font"}, false),
+		NewSparseExample([]string{"gpu"}, true),
+		NewSparseExample([]string{"font"}, false),
+	}
+	r := rand.New(rand.NewSource(42))
+	features := TokenFeatures([]string{"crash", "font", "gpu"})
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	if err := a.Train(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := a.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAdaBoost(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, after := a.FeatureImportance(), loaded.FeatureImportance()
+	if len(before) == 0 || !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the same importance after loading, %v, but was %v", before, after)
+	}
+}
+
+func TestPredictProba(t *testing.T) {
+	e := &datum{"red", "heavy", true}
+	if p := (&AdaBoost{}).PredictProba(e); 0.5 != p {
+		t.Errorf("expected probability 0.5 at margin 0 but was %f", p)
+	}
+	last := 0.0
+	for _, alpha := range []float64{-3.0, -1.0, -0.1, 0.1, 1.0, 3.0} {
+		a := &AdaBoost{H: []Classifier{&colorFeature{"red"}}, A: []float64{alpha}}
+		p := a.PredictProba(e)
+		if p <= 0.0 || 1.0 <= p {
+			t.Errorf("expected a probability strictly between 0 and 1 at margin %f but was %f", alpha, p)
+		}
+		if p <= last {
+			t.Errorf("expected probability to increase with the margin but was %f at %f after %f", p, alpha, last)
+		}
+		last = p
+	}
+}
+
+func TestFeatureFunc(t *testing.T) {
+	red := FeatureFunc("is-red", func(e Example) bool { return e.(*datum).color == "red" })
+	if 1.0 != red.Predict(&datum{"red", "heavy", true}) || -1.0 != red.Predict(&datum{"yellow", "heavy", true}) {
+		t.Errorf("a FeatureFunc should fire exactly when its test is true")
+	}
+	if "is-red" != red.String() || "is-red" != red.ID() {
+		t.Errorf("expected a FeatureFunc to be named and identified by is-red but was %s and %s", red, red.ID())
+	}
+	a := &AdaBoost{H: []Classifier{&colorFeature{"red"}, And(&colorFeature{"red"}, red)}, A: []float64{1.0, 0.5}}
+	var b bytes.Buffer
+	err := a.Save(&b)
+	if err == nil || !strings.Contains(err.Error(), "is-red is a FeatureFunc") {
+		t.Errorf("saving a FeatureFunc should fail naming it but was %v", err)
+	}
+	if 0 != b.Len() {
+		t.Errorf("a failed save should write nothing but wrote %q", b.String())
+	}
+}
+
+func TestAndOr(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	heavy := &reflectedFeature{"Weight", "heavy"}
+	e := &datum{"red", "light", true}
+	if -1.0 != And(red, yellow, heavy).Predict(e) || 1.0 != And(red).Predict(e) {
+		t.Errorf("And should fire only when all of its features do")
+	}
+	if 1.0 != Or(yellow, heavy, red).Predict(e) || -1.0 != Or(yellow, heavy).Predict(e) {
+		t.Errorf("Or should fire when any of its features do")
+	}
+	if s := Or(red, yellow, heavy).String(); "color*red || color*yellow || Weight*heavy" != s {
+		t.Errorf("unexpected description of disjunction: %s", s)
+	}
+}
+
+func TestCrossValidate(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "heavy", false},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	errors, err := CrossValidate(dataset, NewDecisionStumper(features, dataset, r), 3, 2, 4, r)
+	if err != nil || 3 != len(errors) {
+		t.Fatalf("expected an error rate for each of 3 folds but was %v, %v", errors, err)
+	}
+	for _, e := range errors {
+		if e < 0.0 || 1.0 < e {
+			t.Errorf("expected error rates between 0 and 1 but was %v", errors)
+		}
+	}
+	for _, k := range []int{1, 7} {
+		if _, err := CrossValidate(dataset, NewDecisionStumper(features, dataset, r), k, 2, 4, r); err == nil {
+			t.Errorf("cross validating 6 examples with %d folds should fail", k)
+		}
+	}
+}
+
+func TestTrainTestSplit(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	original := append([]Example(nil), dataset...)
+	r := rand.New(rand.NewSource(0))
+	train, test := TrainTestSplit(dataset, 0.25, r)
+	if 3 != len(train) || 1 != len(test) {
+		t.Errorf("expected 3 training and 1 test example but was %d and %d", len(train), len(test))
+	}
+	if !reflect.DeepEqual(original, dataset) {
+		t.Errorf("splitting should not modify the examples")
+	}
+	if train, test := TrainTestSplit(dataset, 0.0, r); 4 != len(train) || 0 != len(test) {
+		t.Errorf("a test fraction of 0 should produce an empty test set")
+	}
+	if train, test := TrainTestSplit(dataset, 1.0, r); 0 != len(train) || 4 != len(test) {
+		t.Errorf("a test fraction of 1 should produce an empty training set")
+	}
+}
+
+func TestNewAdaBoostWeighted(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+	}
+	r := rand.New(rand.NewSource(0))
+	a, err := NewAdaBoostWeighted(dataset, nil, r, []float64{3.0, 1.0})
+	if err != nil {
+		t.Fatalf("constructing a weighted booster should succeed but was %v", err)
+	}
+	if !reflect.DeepEqual([]float64{0.75, 0.25}, a.D.P) {
+		t.Errorf("expected initial distribution [0.75 0.25] but was %v", a.D.P)
+	}
+	if _, err := NewAdaBoostWeighted(dataset, nil, r, []float64{1.0}); err == nil {
+		t.Errorf("constructing a booster with too few weights should fail")
+	}
+}
+
+func TestNewAdaBoostWithClassWeights(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", false},
+	}
+	r := rand.New(rand.NewSource(0))
+	a, err := NewAdaBoostWithClassWeights(dataset, nil, r, map[Label]float64{true: 3.0})
+	if err != nil {
+		t.Fatalf("constructing a class weighted booster should succeed but was %v", err)
+	}
+	if !reflect.DeepEqual([]float64{0.5, 1.0 / 6.0, 1.0 / 6.0, 1.0 / 6.0}, a.D.P) {
+		t.Errorf("expected the positive example to have half the weight but was %v", a.D.P)
+	}
+	if a, _ := NewAdaBoostWithClassWeights(dataset, nil, r, nil); !reflect.DeepEqual(UniformDistribution(4), a.D) {
+		t.Errorf("expected a uniform distribution without class weights but was %v", a.D.P)
+	}
+}
+
+func TestTrainErrors(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	a.Train(2, 4)
+	e := a.ConfusionMatrix(dataset).ErrorRate()
+	a.Train(1, 4)
+	if len(a.TrainErrors) != 3 || a.TrainErrors[2] != a.ConfusionMatrix(dataset).ErrorRate() {
+		t.Errorf("expected a training error per round but was %v", a.TrainErrors)
+	}
+	a.Truncate(2)
+	if len(a.TrainErrors) != 2 || a.TrainErrors[1] != e {
+		t.Errorf("truncating should truncate the training errors but was %v", a.TrainErrors)
+	}
+}
+
+func TestOnRound(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	a := NewAdaBoost(dataset, NewDecisionStumper(features, dataset, r), r)
+	var rounds []int
+	a.OnRound = func(round int, h Classifier, trainError float64) {
+		if h != a.H[round] {
+			t.Errorf("round %d should report its classifier", round)
+		}
+		if trainError != a.ConfusionMatrix(dataset).ErrorRate() {
+			t.Errorf("round %d reported training error %f", round, trainError)
+		}
+		rounds = append(rounds, round)
+	}
+	a.Train(3, 4)
+	if !reflect.DeepEqual([]int{0, 1, 2}, rounds) {
+		t.Errorf("expected OnRound to be called for rounds [0 1 2] but was %v", rounds)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{H: []Classifier{red}, A: []float64{1.0}}
+	test := []Example{
+		// Correct positive and negative predictions.
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", false},
+		// A false positive and a false negative.
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	if e := a.Evaluate(test); 0.4 != e {
+		t.Errorf("expected error rate 0.4 but was %f", e)
+	}
+}
+
+func TestMargins(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	a := &AdaBoost{H: []Classifier{red, &FeatureNegater{yellow}}, A: []float64{3.0, 1.0}}
+	test := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"yellow", "light", true},
+	}
+	if m := a.Margins(test); !reflect.DeepEqual([]float64{1.0, -1.0}, m) {
+		t.Errorf("expected margins [1 -1] but was %v", m)
+	}
+}
+
+func TestRule(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	a := &AdaBoost{
+		H: []Classifier{
+			&FeatureNegater{red},
+			&FeatureNode{yellow, &LeafNode{true}, &LeafNode{false}},
+			&abstainingStump{red, -1.0},
+		},
+		A: []float64{0.5, 0.25, 1.0},
+	}
+	expected := []string{
+		"0.500000: IF not(color*red) THEN +1 ELSE -1",
+		"0.250000: IF color*yellow THEN (+1) ELSE (-1)",
+		"1.000000: IF color*red THEN -1 ELSE 0",
+	}
+	if rules := a.Rules(); !reflect.DeepEqual(expected, rules) {
+		t.Errorf("expected rules %q but was %q", expected, rules)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	red := &colorFeature{"red"}
+	yellow := &colorFeature{"yellow"}
+	a := &AdaBoost{
+		H: []Classifier{
+			&FeatureNegater{red},
+			&FeatureNode{yellow, &LeafNode{true}, &LeafNode{false}},
+			&abstainingStump{red, -1.0},
+		},
+		A: []float64{0.5, 0.25, 1.0},
+	}
+	expected := []Contribution{
+		{2, "IF color*red THEN -1 ELSE 0", -1.0},
+		{0, "IF not(color*red) THEN +1 ELSE -1", -0.5},
+		{1, "IF color*yellow THEN (+1) ELSE (-1)", -0.25},
+	}
+	if c := a.Explain(&datum{"red", "heavy", true}); !reflect.DeepEqual(expected, c) {
+		t.Errorf("expected contributions %v but was %v", expected, c)
+	}
+}
+
+func TestClassifierConstructors(t *testing.T) {
+	red := &colorFeature{"red"}
+	tree := NewFeatureNode(red, NewLeafNode(false), NewAbstainingStump(red, 1.0))
+	if "IF color*red THEN (-1) ELSE (IF color*red THEN +1 ELSE 0)" != Rule(tree) {
+		t.Errorf("unexpected tree %s", Rule(tree))
+	}
+	if -1.0 != tree.Predict(&datum{"red", "heavy", true}) || 0.0 != tree.Predict(&datum{"yellow", "heavy", true}) {
+		t.Errorf("unexpected predictions from %s", Rule(tree))
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	red := &colorFeature{"red"}
+	a := &AdaBoost{
+		H: []Classifier{&FeatureNode{red, &LeafNode{true}, &LeafNode{false}}},
+		A: []float64{0.5},
+	}
+	var b bytes.Buffer
+	if err := a.WriteDOT(&b); err != nil {
+		t.Fatalf("writing DOT should succeed but was %v", err)
+	}
+	expected := `digraph ensemble {
+  subgraph cluster_0 {
+    label="round 0, weight 0.500000";
+    n0 [label="color*red"];
+    n1 [label="+1", shape=plaintext];
+    n2 [label="-1", shape=plaintext];
+    n0 -> n1 [label="yes"];
+    n0 -> n2 [label="no"];
+  }
+}
+`
+	if expected != b.String() {
+		t.Errorf("unexpected DOT:\n%s", b.String())
+	}
+}
+
+func TestSparseExample(t *testing.T) {
+	e := NewSparseExample([]string{"crash", "tab", "crash"}, true)
+	fs := TokenFeatures([]string{"crash", "print"})
+	if 1.0 != fs[0].Predict(e) || -1.0 != fs[1].Predict(e) {
+		t.Errorf("expected crash to fire and print not to fire on %v", e)
+	}
+	if !e.Label() {
+		t.Errorf("expected the example to be labeled")
+	}
+}
+
+func TestHashFeatures(t *testing.T) {
+	vocabulary := []string{"crash", "tab", "print", "font", "scroll"}
+	fs, err := HashFeatures(vocabulary, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) == 0 || len(fs) > 2 {
+		t.Errorf("expected at most 2 features but was %v", fs)
+	}
+	for _, token := range vocabulary {
+		e := NewSparseExample([]string{token}, true)
+		fired := 0
+		for _, f := range fs {
+			if 1.0 == f.Predict(e) {
+				fired++
+			}
+		}
+		if 1 != fired {
+			t.Errorf("expected exactly one bucket to fire on %s but %d did", token, fired)
+		}
+	}
+	a := &AdaBoost{H: []Classifier{fs[0]}, A: []float64{1.0}}
+	var b bytes.Buffer
+	if err := a.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadAdaBoost(&b)
+	if err != nil || !reflect.DeepEqual(fs[0], loaded.H[0]) {
+		t.Errorf("expected to load %v but was %v, %v", fs[0], loaded, err)
+	}
+	if _, err := LoadAdaBoost(strings.NewReader(`{"h":[{"type":"hashed","value":{"Bucket":1,"Tokens":["font"]}}],"a":[1]}`)); err == nil {
+		t.Errorf("loading a hashed feature without buckets should fail")
+	}
+	for _, buckets := range []int{0, -1} {
+		if _, err := HashFeatures(vocabulary, buckets, 0); err == nil {
+			t.Errorf("hashing into %d buckets should fail", buckets)
+		}
+	}
+}
+
+func TestStumpError(t *testing.T) {
+	// Fires on 1 of 4 examples, which is negative; 3 are positive.
+	if error, negate := stumpError([3]int{1, 0, 3}, 4); 0.0 != error || !negate {
+		t.Errorf("expected a stump which is always wrong to be negated with error 0 but was %f, %v", error, negate)
+	}
+	if error, negate := stumpError([3]int{3, 3, 3}, 4); 0.0 != error || negate {
+		t.Errorf("expected a stump which is always right to be kept with error 0 but was %f, %v", error, negate)
+	}
+}
+
+func TestDecisionStumperRemovesDuplicateFeatures(t *testing.T) {
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+		&reflectedFeature{"Color", "red"},
+	}
+	stumper := NewDecisionStumper(features, nil, rand.New(rand.NewSource(0)))
+	if 2 != len(stumper.features) || features[0] != stumper.features[0] || features[1] != stumper.features[1] {
+		t.Errorf("expected the duplicate feature to be removed but features were %v", stumper.features)
+	}
+	if 1 != stumper.DuplicateFeatures() {
+		t.Errorf("expected 1 duplicate feature but was %d", stumper.DuplicateFeatures())
+	}
+}
+
+func benchmarkModel() (*AdaBoost, []Example) {
+	r := rand.New(rand.NewSource(0))
+	var vocabulary []string
+	for i := 0; i < 100; i++ {
+		vocabulary = append(vocabulary, fmt.Sprintf("w%d", i))
+	}
+	features := TokenFeatures(vocabulary)
+	a := &AdaBoost{}
+	for i := 0; i < 1000; i++ {
+		a.H = append(a.H, And(features[r.Intn(len(features))], features[r.Intn(len(features))]))
+		a.A = append(a.A, r.Float64())
+	}
+	var examples []Example
+	for i := 0; i < 1000; i++ {
+		var tokens []string
+		for j := 0; j < 20; j++ {
+			tokens = append(tokens, vocabulary[r.Intn(len(vocabulary))])
+		}
+		examples = append(examples, NewSparseExample(tokens, r.Intn(2) == 0))
+	}
+	return a, examples
+}
+
+func TestPredictBatch(t *testing.T) {
+	a, examples := benchmarkModel()
+	scores := a.PredictBatch(examples)
+	for i, example := range examples {
+		if a.Predict(example) != scores[i] {
+			t.Fatalf("batch score %d was %f but expected %f", i, scores[i], a.Predict(example))
+		}
+	}
+}
+
+func BenchmarkPredict(b *testing.B) {
+	a, examples := benchmarkModel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, example := range examples {
+			a.Predict(example)
+		}
+	}
+}
+
+func BenchmarkPredictBatch(b *testing.B) {
+	a, examples := benchmarkModel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.PredictBatch(examples)
+	}
+}
+
+func BenchmarkConfusionMatrix(b *testing.B) {
+	a, examples := benchmarkModel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.ConfusionMatrix(examples)
+	}
+}
+
+func TestConfusionMatrixMatchesSerial(t *testing.T) {
+	a, examples := benchmarkModel()
+	var serial ConfusionMatrix
+	for _, example := range examples {
+		serial.add(Label(a.Predict(example) > 0.0), example.Label())
+	}
+	if m := a.ConfusionMatrix(examples); serial != m {
+		t.Errorf("expected confusion matrix %v but was %v", serial, m)
+	}
+}
+
+func TestSoftmaxDistribution(t *testing.T) {
+	d := SoftmaxDistribution([]float64{1000.0, 1000.0 + math.Log(3.0)}, 1.0)
+	if math.Abs(d.P[0]-0.25) > 1e-12 || math.Abs(d.P[1]-0.75) > 1e-12 {
+		t.Errorf("expected softmax [0.25 0.75] but was %v", d.P)
+	}
+	if d := SoftmaxDistribution([]float64{1.0, 3.0, 3.0}, 0.0); !reflect.DeepEqual([]float64{0.0, 1.0, 0.0}, d.P) {
+		t.Errorf("expected zero temperature softmax [0 1 0] but was %v", d.P)
+	}
+	for _, temperature := range []float64{0.0, 1.0} {
+		if d := SoftmaxDistribution(nil, temperature); 0 != len(d.P) {
+			t.Errorf("expected softmax of no logits to be empty but was %v", d.P)
+		}
+	}
+}
+
+func TestMixture(t *testing.T) {
+	p := &Distribution{[]float64{1.0, 0.0}}
+	q := &Distribution{[]float64{0.0, 1.0}}
+	m, err := Mixture([]float64{3.0, 1.0}, []*Distribution{p, q})
+	if err != nil {
+		t.Fatalf("mixing distributions should succeed but was %v", err)
+	}
+	if !reflect.DeepEqual([]float64{0.75, 0.25}, m.P) {
+		t.Errorf("expected mixture [0.75 0.25] but was %v", m.P)
+	}
+	if _, err := Mixture([]float64{1.0, 1.0}, []*Distribution{p, UniformDistribution(3)}); err == nil {
+		t.Errorf("mixing distributions of different lengths should fail")
+	}
+}
+
+func TestApproxEqual(t *testing.T) {
+	p := &Distribution{[]float64{0.5, 0.5}}
+	if !p.ApproxEqual(&Distribution{[]float64{0.5000001, 0.4999999}}, 1e-6) {
+		t.Errorf("distributions within tolerance should be approximately equal")
+	}
+	if p.ApproxEqual(&Distribution{[]float64{0.6, 0.4}}, 1e-6) || p.ApproxEqual(UniformDistribution(3), 1.0) {
+		t.Errorf("distributions outside tolerance or of different lengths should not be approximately equal")
+	}
+}
+
+func TestWeightedError(t *testing.T) {
+	red := &colorFeature{"red"}
+	examples := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+		&datum{"yellow", "light", true},
+	}
+	d := &Distribution{[]float64{0.5, 0.2, 0.3}}
+	if e := WeightedError(red, examples, d); 0.5 != e {
+		t.Errorf("expected weighted error 0.5 but was %f", e)
+	}
+	if e := WeightedError(&abstainingStump{red, 1.0}, examples, d); 0.2 != e {
+		t.Errorf("abstentions should not be errors, expected 0.2 but was %f", e)
+	}
+}
+
+func BenchmarkDecisionStumper(b *testing.B) {
+	_, examples := benchmarkModel()
+	var vocabulary []string
+	for i := 0; i < 100; i++ {
+		vocabulary = append(vocabulary, fmt.Sprintf("w%d", i))
+	}
+	stumper := NewDecisionStumper(TokenFeatures(vocabulary), examples, rand.New(rand.NewSource(0)))
+	stumper.NewClassifier(examples)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stumper.NewClassifier(examples)
+	}
+}
+
+func TestAddExamples(t *testing.T) {
+	dataset := []Example{
+		&datum{"red", "heavy", true},
+		&datum{"red", "light", false},
+	}
+	features := []Feature{
+		&reflectedFeature{"Color", "red"},
+		&reflectedFeature{"Weight", "heavy"},
+	}
+	r := rand.New(rand.NewSource(42))
+	stumper := NewDecisionStumper(features, dataset, r)
+	a := NewAdaBoost(dataset, stumper, r)
+	a.Round(2)
+	more := []Example{
+		&datum{"yellow", "light", false},
+		&datum{"yellow", "heavy", true},
+	}
+	a.AddExamples(more)
+	if 4 != len(a.Examples) || 4 != len(a.D.P) || 4 != len(stumper.examples) || 4 != len(stumper.fires[0]) {
+		t.Fatalf("expected 4 examples with weights and cached features")
+	}
+	if 0.25 != a.D.P[2] || 0.25 != a.D.P[3] || math.Abs(a.D.P[0]+a.D.P[1]-0.5) > 1e-12 {
+		t.Errorf("expected new examples to have weight 0.25 and old ones 0.5 in total but was %v", a.D.P)
+	}
+	if !stumper.fires[1][3] || stumper.fires[1][2] {
+		t.Errorf("expected cached features for the new examples")
+	}
+}
+
+func TestAddExamplesToLoadedModel(t *testing.T) {
+	a, err := LoadAdaBoost(bytes.NewReader(trainSavedModel(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AddExamples([]Example{
+		NewSparseExample([]string{"crash"}, true),
+		NewSparseExample([]string{"font"}, false),
+	})
+	if 2 != len(a.Examples) || !reflect.DeepEqual([]float64{0.5, 0.5}, a.D.P) {
+		t.Errorf("expected 2 uniformly weighted examples but had %d with weights %v", len(a.Examples), a.D.P)
+	}
+}
+
+func trainSavedModel(seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	vocabulary := []string{"crash", "tab", "print", "font", "scroll", "gpu"}
+	var examples []Example
+	for i := 0; i < 50; i++ {
+		var tokens []string
+		for _, token := range vocabulary {
+			if r.Intn(2) == 0 {
+				tokens = append(tokens, token)
+			}
+		}
+		examples = append(examples, NewSparseExample(tokens, r.Intn(3) == 0))
+	}
+	train, test := TrainTestSplit(examples, 0.2, r)
+	a := NewAdaBoost(train, NewDecisionStumper(TokenFeatures(vocabulary), train, r), r)
+	a.Train(5, 20)
+	a.Evaluate(test)
+	var b bytes.Buffer
+	a.Save(&b)
+	return b.Bytes()
+}
+
+func TestTrainingIsReproducible(t *testing.T) {
+	x := trainSavedModel(1)
+	y := trainSavedModel(1)
+	if !bytes.Equal(x, y) {
+		t.Errorf("training twice with the same seed should save identical models:\n%s\n%s", x, y)
+	}
+}
+
+func TestBuildTextExamples(t *testing.T) {
+	examples := BuildTextExamples([]string{"Tab  CRASHES\n", "font"}, []Label{true, false}, nil)
+	e := examples[0].(TokenExample)
+	if !e.HasToken("tab") || !e.HasToken("crashes") || e.HasToken("Tab") || !bool(e.Label()) {
+		t.Errorf("expected a positive example with lowercase tokens tab and crashes")
+	}
+	if bool(examples[1].Label()) || !examples[1].(TokenExample).HasToken("font") {
+		t.Errorf("expected a negative example with token font")
+	}
+}
+
+func TestIDF(t *testing.T) {
+	docs := [][]string{{"tab", "crash", "crash"}, {"tab", "font"}}
+	idf := IDF(docs)
+	if 0.0 != idf["tab"] || math.Log(2.0) != idf["crash"] || 3 != len(idf) {
+		t.Errorf("unexpected IDF %v", idf)
+	}
+	w := TFIDF(docs[0], idf)
+	if math.Abs(w["crash"]-2.0/3.0*math.Log(2.0)) > 1e-9 || 0.0 != w["tab"] {
+		t.Errorf("unexpected TF-IDF %v", w)
+	}
+}
+
+func TestReadExamplesCSV(t *testing.T) {
+	examples, err := ReadExamplesCSV(strings.NewReader("text,label\n\"Tab, crash\",true\nfont,0\n"), 0, 1, true, nil)
+	if err != nil || len(examples) != 2 {
+		t.Fatalf("expected 2 examples but was %v, %v", examples, err)
+	}
+	e := examples[0].(TokenExample)
+	if !e.HasToken("tab,") || !e.HasToken("crash") || !bool(e.Label()) || bool(examples[1].Label()) {
+		t.Errorf("unexpected examples %v", examples)
+	}
+	if _, err := ReadExamplesCSV(strings.NewReader("tab,true\nfont\n"), 0, 1, false, nil); err == nil {
+		t.Errorf("reading ragged rows should fail")
+	}
+	if _, err := ReadExamplesCSV(strings.NewReader("tab,maybe\n"), 0, 1, false, nil); err == nil {
+		t.Errorf("reading an invalid label should fail")
+	}
+	if _, err := ReadExamplesCSV(strings.NewReader("tab,true\n"), 0, -1, false, nil); err == nil {
+		t.Errorf("reading a negative column should fail")
+	}
+}
+
+func TestSyntheticExamples(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	examples, features := SyntheticExamples(200, 5, r)
+	if len(examples) != 200 || len(features) != 5 {
+		t.Fatalf("expected 200 examples and 5 features but was %d and %d", len(examples), len(features))
+	}
+	a := NewAdaBoost(examples, NewDecisionStumper(features, examples, r), r)
+	a.Train(1, 200)
+	if e := a.ConfusionMatrix(examples).ErrorRate(); 0.0 != e {
+		t.Errorf("expected to learn the planted labeling but had error %f", e)
+	}
+}
+
+func TestDenseExample(t *testing.T) {
+	vocabulary := []string{"tab", "crash", "font"}
+	e := NewDenseExample(vocabulary, []string{"crash", "gpu", "tab"}, true)
+	features := IndexFeatures(vocabulary)
+	for i, expected := range []float64{1.0, 1.0, -1.0} {
+		if p := features[i].Predict(e); expected != p {
+			t.Errorf("expected %s to predict %f but was %f", features[i], expected, p)
+		}
+	}
+	if !bool(e.Label()) || e.Value(2) {
+		t.Errorf("unexpected example %v", e)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	a := NewSparseExample([]string{"tab", "crash"}, true)
+	b := NewSparseExample([]string{"crash", "font", "gpu", "crash"}, false)
+	if j := JaccardSimilarity(a, b); 0.25 != j {
+		t.Errorf("expected Jaccard similarity 0.25 but was %f", j)
+	}
+	if c := CosineSimilarity(a, b); math.Abs(c-1.0/math.Sqrt(6.0)) > 1e-12 {
+		t.Errorf("expected cosine similarity %f but was %f", 1.0/math.Sqrt(6.0), c)
+	}
+	empty := NewSparseExample(nil, false)
+	if 0.0 != JaccardSimilarity(empty, empty) || 0.0 != CosineSimilarity(a, empty) {
+		t.Errorf("similarity with no tokens should be 0.0")
+	}
+}
+
+func TestPredictionFeature(t *testing.T) {
+	red := &colorFeature{"red"}
+	first := (&AdaBoost{H: []Classifier{red}, A: []float64{0.25}}).Freeze()
+	f := &PredictionFeature{"red-model", first}
+	if 1.0 != f.Predict(&datum{"red", "heavy", true}) || -1.0 != f.Predict(&datum{"yellow", "heavy", true}) {
+		t.Errorf("expected %s to fire exactly when the first model predicts positive", f)
+	}
+	second := &AdaBoost{H: []Classifier{Not(f)}, A: []float64{1.0}}
+	if 1.0 != second.Predict(&datum{"yellow", "light", true}) {
+		t.Errorf("expected the second model to vote on the first model's prediction")
+	}
+}
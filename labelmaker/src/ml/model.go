@@ -0,0 +1,51 @@
+package ml
+
+import (
+	"io"
+	"math"
+)
+
+// Model is a trained ensemble which can only predict. It holds the
+// classifiers and their weights but none of the training state, and
+// they cannot be changed, so a model being served cannot be retrained
+// by accident. A Model is itself a Classifier.
+type Model struct {
+	h []Classifier
+	a []float64
+}
+
+// Freeze returns a Model which predicts as the ensemble currently
+// does. Later training does not affect it.
+func (a *AdaBoost) Freeze() *Model {
+	return &Model{append([]Classifier(nil), a.H...), append([]float64(nil), a.A...)}
+}
+
+// LoadModel reads an ensemble written by AdaBoost.Save as a Model.
+func LoadModel(r io.Reader) (*Model, error) {
+	a, err := LoadAdaBoost(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Model{a.H, a.A}, nil
+}
+
+// Predict returns the weighted vote of the classifiers, like
+// AdaBoost.Predict.
+func (m *Model) Predict(e Example) float64 {
+	sum := 0.0
+	for i, h := range m.h {
+		sum += m.a[i] * h.Predict(e)
+	}
+	return sum
+}
+
+// PredictLabel returns the class Predict's score indicates.
+func (m *Model) PredictLabel(e Example) Label {
+	return Label(m.Predict(e) > 0.0)
+}
+
+// PredictProba returns the probability that e is in the positive
+// class, like AdaBoost.PredictProba.
+func (m *Model) PredictProba(e Example) float64 {
+	return 1.0 / (1.0 + math.Exp(-2.0*m.Predict(e)))
+}
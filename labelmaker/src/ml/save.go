@@ -0,0 +1,304 @@
+package ml
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var classifierTypes = make(map[string]reflect.Type)
+var classifierNames = make(map[reflect.Type]string)
+
+// RegisterClassifier records the concrete type of c under name so
+// that models containing it can be saved and loaded. Classifiers are
+// encoded with encoding/json, so the type must have exported fields
+// or implement json.Marshaler and json.Unmarshaler. Features are
+// classifiers and are registered the same way.
+func RegisterClassifier(name string, c Classifier) {
+	t := reflect.TypeOf(c)
+	if _, ok := classifierTypes[name]; ok {
+		panic(fmt.Sprintf("classifier type %s registered twice", name))
+	}
+	classifierTypes[name] = t
+	classifierNames[t] = name
+}
+
+func init() {
+	RegisterClassifier("and", &andFeature{})
+	RegisterClassifier("or", &orFeature{})
+	RegisterClassifier("not", &FeatureNegater{})
+	RegisterClassifier("node", &FeatureNode{})
+	RegisterClassifier("leaf", &LeafNode{})
+	RegisterClassifier("abstain", &abstainingStump{})
+	RegisterClassifier("token", &TokenFeature{})
+	RegisterClassifier("hashed", &HashedFeature{})
+	RegisterClassifier("index", &IndexFeature{})
+}
+
+type savedClassifier struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func encodeClassifier(c Classifier) (*savedClassifier, error) {
+	if f, ok := c.(*funcFeature); ok {
+		return nil, fmt.Errorf("feature %s is a FeatureFunc, which cannot be saved", f)
+	}
+	name, ok := classifierNames[reflect.TypeOf(c)]
+	if !ok {
+		return nil, fmt.Errorf("classifier type %T is not registered", c)
+	}
+	value, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return &savedClassifier{name, value}, nil
+}
+
+func decodeClassifier(s *savedClassifier) (Classifier, error) {
+	if s == nil {
+		return nil, errors.New("missing classifier")
+	}
+	t, ok := classifierTypes[s.Type]
+	if !ok {
+		return nil, fmt.Errorf("classifier type %s is not registered", s.Type)
+	}
+	var v reflect.Value
+	if t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem())
+		if err := json.Unmarshal(s.Value, v.Interface()); err != nil {
+			return nil, err
+		}
+	} else {
+		p := reflect.New(t)
+		if err := json.Unmarshal(s.Value, p.Interface()); err != nil {
+			return nil, err
+		}
+		v = p.Elem()
+	}
+	return v.Interface().(Classifier), nil
+}
+
+func decodeFeature(s *savedClassifier) (Feature, error) {
+	c, err := decodeClassifier(s)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := c.(Feature)
+	if !ok {
+		return nil, fmt.Errorf("classifier type %s is not a feature", s.Type)
+	}
+	return f, nil
+}
+
+func encodeFeaturePair(f1 Feature, f2 Feature) ([]byte, error) {
+	s1, err := encodeClassifier(f1)
+	if err != nil {
+		return nil, err
+	}
+	s2, err := encodeClassifier(f2)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal([]*savedClassifier{s1, s2})
+}
+
+func decodeFeaturePair(data []byte) (Feature, Feature, error) {
+	var fs []*savedClassifier
+	if err := json.Unmarshal(data, &fs); err != nil {
+		return nil, nil, err
+	}
+	if len(fs) != 2 {
+		return nil, nil, fmt.Errorf("expected 2 features but had %d", len(fs))
+	}
+	f1, err := decodeFeature(fs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	f2, err := decodeFeature(fs[1])
+	return f1, f2, err
+}
+
+func (f *andFeature) MarshalJSON() ([]byte, error) {
+	return encodeFeaturePair(f.f1, f.f2)
+}
+
+func (f *andFeature) UnmarshalJSON(data []byte) error {
+	var err error
+	f.f1, f.f2, err = decodeFeaturePair(data)
+	return err
+}
+
+func (f *orFeature) MarshalJSON() ([]byte, error) {
+	return encodeFeaturePair(f.f1, f.f2)
+}
+
+func (f *orFeature) UnmarshalJSON(data []byte) error {
+	var err error
+	f.f1, f.f2, err = decodeFeaturePair(data)
+	return err
+}
+
+func (f *FeatureNegater) MarshalJSON() ([]byte, error) {
+	s, err := encodeClassifier(f.Feature)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+func (f *FeatureNegater) UnmarshalJSON(data []byte) error {
+	var s savedClassifier
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	var err error
+	f.Feature, err = decodeFeature(&s)
+	return err
+}
+
+type featureNodeJson struct {
+	Feature  *savedClassifier `json:"feature"`
+	Positive *savedClassifier `json:"positive"`
+	Negative *savedClassifier `json:"negative"`
+}
+
+func (n *FeatureNode) MarshalJSON() ([]byte, error) {
+	var j featureNodeJson
+	var err error
+	if j.Feature, err = encodeClassifier(n.feature); err != nil {
+		return nil, err
+	}
+	if j.Positive, err = encodeClassifier(n.positive); err != nil {
+		return nil, err
+	}
+	if j.Negative, err = encodeClassifier(n.negative); err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+func (n *FeatureNode) UnmarshalJSON(data []byte) error {
+	var j featureNodeJson
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Feature == nil || j.Positive == nil || j.Negative == nil {
+		return fmt.Errorf("feature node is incomplete: %s", data)
+	}
+	var err error
+	if n.feature, err = decodeFeature(j.Feature); err != nil {
+		return err
+	}
+	if n.positive, err = decodeClassifier(j.Positive); err != nil {
+		return err
+	}
+	n.negative, err = decodeClassifier(j.Negative)
+	return err
+}
+
+func (n *LeafNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.class)
+}
+
+func (n *LeafNode) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &n.class)
+}
+
+type hashedFeatureJson HashedFeature
+
+func (f *HashedFeature) UnmarshalJSON(data []byte) error {
+	var j hashedFeatureJson
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Buckets <= 0 || j.Bucket < 0 || j.Bucket >= j.Buckets {
+		return fmt.Errorf("hashed feature has bucket %d of %d: %s", j.Bucket, j.Buckets, data)
+	}
+	*f = HashedFeature(j)
+	return nil
+}
+
+type abstainingStumpJson struct {
+	Feature *savedClassifier `json:"feature"`
+	Vote    float64          `json:"vote"`
+}
+
+func (s *abstainingStump) MarshalJSON() ([]byte, error) {
+	f, err := encodeClassifier(s.feature)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(abstainingStumpJson{f, s.vote})
+}
+
+func (s *abstainingStump) UnmarshalJSON(data []byte) error {
+	var j abstainingStumpJson
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Feature == nil {
+		return fmt.Errorf("abstaining stump has no feature: %s", data)
+	}
+	var err error
+	s.feature, err = decodeFeature(j.Feature)
+	s.vote = j.Vote
+	return err
+}
+
+type adaBoostJson struct {
+	H           []*savedClassifier `json:"h"`
+	A           []float64          `json:"a"`
+	Eta         *float64           `json:"eta,omitempty"`
+	TrainErrors []float64          `json:"train_errors,omitempty"`
+}
+
+// Save writes the trained ensemble to w: its classifiers, their
+// weights, Eta and TrainErrors. The training examples, D, the learner
+// and OnRound are not saved. Every classifier in the ensemble,
+// including the features it uses, must have been registered with
+// RegisterClassifier.
+func (a *AdaBoost) Save(w io.Writer) error {
+	var j adaBoostJson
+	for _, h := range a.H {
+		s, err := encodeClassifier(h)
+		if err != nil {
+			return err
+		}
+		j.H = append(j.H, s)
+	}
+	j.A = a.A
+	j.Eta = &a.Eta
+	j.TrainErrors = a.TrainErrors
+	return json.NewEncoder(w).Encode(j)
+}
+
+// LoadAdaBoost reads an ensemble written by Save. The loaded model
+// can Predict and Evaluate but has no training examples. Its
+// TrainErrors are those recorded when it was trained. Models saved
+// without Eta load with an Eta of 1.0.
+func LoadAdaBoost(r io.Reader) (*AdaBoost, error) {
+	var j adaBoostJson
+	if err := json.NewDecoder(r).Decode(&j); err != nil {
+		return nil, err
+	}
+	if len(j.H) != len(j.A) {
+		return nil, fmt.Errorf("model has %d classifiers but %d weights", len(j.H), len(j.A))
+	}
+	a := &AdaBoost{Eta: 1.0}
+	if j.Eta != nil {
+		a.Eta = *j.Eta
+	}
+	for _, s := range j.H {
+		h, err := decodeClassifier(s)
+		if err != nil {
+			return nil, err
+		}
+		a.H = append(a.H, h)
+	}
+	a.A = j.A
+	a.TrainErrors = j.TrainErrors
+	return a, nil
+}
@@ -0,0 +1,52 @@
+package ml
+
+// ExampleSource produces examples one at a time, so a large test set
+// can be evaluated without holding all of it in memory. Next returns
+// false once the source is exhausted.
+//
+// Training still needs its examples in memory: boosting resamples
+// them by weight every round, and the stumper caches each feature's
+// value on each of them. Collect reads a source into a slice for
+// training, and NewDecisionStumperFromSource builds the stumper's
+// cache while reading.
+type ExampleSource interface {
+	Next() (Example, bool)
+}
+
+type sliceSource struct {
+	examples []Example
+}
+
+func (s *sliceSource) Next() (Example, bool) {
+	if len(s.examples) == 0 {
+		return nil, false
+	}
+	e := s.examples[0]
+	s.examples = s.examples[1:]
+	return e, true
+}
+
+// SliceSource returns an ExampleSource which produces examples in
+// order.
+func SliceSource(examples []Example) ExampleSource {
+	return &sliceSource{examples}
+}
+
+// Collect reads the remaining examples from src.
+func Collect(src ExampleSource) []Example {
+	var examples []Example
+	for e, ok := src.Next(); ok; e, ok = src.Next() {
+		examples = append(examples, e)
+	}
+	return examples
+}
+
+// ConfusionMatrixOf is like ConfusionMatrix but reads the test set
+// from src, holding one example at a time.
+func (a *AdaBoost) ConfusionMatrixOf(src ExampleSource) ConfusionMatrix {
+	var m ConfusionMatrix
+	for e, ok := src.Next(); ok; e, ok = src.Next() {
+		m.add(Label(a.Predict(e) > 0.0), e.Label())
+	}
+	return m
+}
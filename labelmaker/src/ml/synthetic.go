@@ -0,0 +1,34 @@
+package ml
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// SyntheticExamples returns n random examples over nFeatures token
+// features, each present with probability 1/2, and the features. An
+// example is positive exactly when it has both f0 and f1, so a
+// stumper over the features can learn the labeling with no error.
+// This is for tests and benchmarks; nFeatures must be at least 2.
+func SyntheticExamples(n int, nFeatures int, r *rand.Rand) ([]Example, []Feature) {
+	if nFeatures < 2 {
+		panic(fmt.Sprintf("need at least 2 features but had %d", nFeatures))
+	}
+	vocabulary := make([]string, nFeatures)
+	for i := range vocabulary {
+		vocabulary[i] = fmt.Sprintf("f%d", i)
+	}
+	examples := make([]Example, n)
+	for i := range examples {
+		var tokens []string
+		for _, token := range vocabulary {
+			if r.Intn(2) == 0 {
+				tokens = append(tokens, token)
+			}
+		}
+		e := NewSparseExample(tokens, false)
+		e.label = Label(e.HasToken("f0") && e.HasToken("f1"))
+		examples[i] = e
+	}
+	return examples, TokenFeatures(vocabulary)
+}
@@ -0,0 +1,274 @@
+package ml
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TokenExample is an example of a document made up of tokens, such as
+// words.
+type TokenExample interface {
+	Example
+	HasToken(token string) bool
+}
+
+// TokenFeature fires on TokenExamples which contain Token.
+type TokenFeature struct {
+	Token string
+}
+
+func (f *TokenFeature) String() string {
+	return f.Token
+}
+
+func (f *TokenFeature) ID() string {
+	return "token(" + f.Token + ")"
+}
+
+func (f *TokenFeature) Predict(e Example) float64 {
+	if e.(TokenExample).HasToken(f.Token) {
+		return 1.0
+	} else {
+		return -1.0
+	}
+}
+
+// TokenFeatures returns a TokenFeature for each token in vocabulary.
+func TokenFeatures(vocabulary []string) []Feature {
+	features := make([]Feature, len(vocabulary))
+	for i, token := range vocabulary {
+		features[i] = &TokenFeature{token}
+	}
+	return features
+}
+
+// TokenLister is a TokenExample which can list the tokens it
+// contains.
+type TokenLister interface {
+	TokenExample
+	Tokens() []string
+}
+
+// HashedFeature fires on TokenListers which contain a token hashed to
+// its bucket, of Buckets buckets, with Seed. It hashes the example's
+// tokens, so it stores no vocabulary and costs time in proportion to
+// the size of the example.
+type HashedFeature struct {
+	Bucket  int
+	Buckets int
+	Seed    uint32
+}
+
+func (f *HashedFeature) String() string {
+	return fmt.Sprintf("bucket*%d", f.Bucket)
+}
+
+func (f *HashedFeature) ID() string {
+	return fmt.Sprintf("hashed(%d/%d:%d)", f.Bucket, f.Buckets, f.Seed)
+}
+
+func (f *HashedFeature) Predict(e Example) float64 {
+	for _, token := range e.(TokenLister).Tokens() {
+		if hashBucket(token, f.Buckets, f.Seed) == f.Bucket {
+			return 1.0
+		}
+	}
+	return -1.0
+}
+
+// HashFeatures hashes each token in vocabulary into one of buckets
+// buckets and returns a HashedFeature for each bucket which received a
+// token. This bounds the number of features, at the cost of merging
+// tokens which collide; tokens outside the vocabulary fire the bucket
+// they hash to too. Different seeds produce different hashes. It is an
+// error for there to be no buckets.
+func HashFeatures(vocabulary []string, buckets int, seed uint32) ([]Feature, error) {
+	if buckets <= 0 {
+		return nil, fmt.Errorf("cannot hash tokens into %d buckets", buckets)
+	}
+	used := make([]bool, buckets)
+	for _, token := range vocabulary {
+		used[hashBucket(token, buckets, seed)] = true
+	}
+	var features []Feature
+	for b, ok := range used {
+		if ok {
+			features = append(features, &HashedFeature{b, buckets, seed})
+		}
+	}
+	return features, nil
+}
+
+func hashBucket(token string, buckets int, seed uint32) int {
+	h := fnv.New32a()
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], seed)
+	h.Write(b[:])
+	h.Write([]byte(token))
+	return int(h.Sum32() % uint32(buckets))
+}
+
+// SparseExample is a TokenExample which stores the set of tokens it
+// contains.
+type SparseExample struct {
+	tokens map[string]bool
+	label  Label
+}
+
+// NewSparseExample returns a SparseExample containing tokens.
+func NewSparseExample(tokens []string, label Label) *SparseExample {
+	e := &SparseExample{make(map[string]bool, len(tokens)), label}
+	for _, token := range tokens {
+		e.tokens[token] = true
+	}
+	return e
+}
+
+func (e *SparseExample) Label() Label {
+	return e.label
+}
+
+func (e *SparseExample) HasToken(token string) bool {
+	return e.tokens[token]
+}
+
+// Tokens returns the tokens e contains, in no particular order.
+func (e *SparseExample) Tokens() []string {
+	tokens := make([]string, 0, len(e.tokens))
+	for token := range e.tokens {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// Tokenize splits text into lowercase, whitespace separated tokens.
+func Tokenize(text string) []string {
+	return strings.Fields(strings.ToLower(text))
+}
+
+// BuildTextExamples returns a SparseExample for each document, made of
+// the tokens tokenize splits it into and the corresponding label. If
+// tokenize is nil, Tokenize is used. It panics if docs and labels have
+// different lengths.
+func BuildTextExamples(docs []string, labels []Label, tokenize func(string) []string) []Example {
+	if len(docs) != len(labels) {
+		panic(fmt.Sprintf("%d documents but %d labels", len(docs), len(labels)))
+	}
+	if tokenize == nil {
+		tokenize = Tokenize
+	}
+	examples := make([]Example, len(docs))
+	for i, doc := range docs {
+		examples[i] = NewSparseExample(tokenize(doc), labels[i])
+	}
+	return examples
+}
+
+// intersection returns the number of tokens a and b have in common.
+func intersection(a *SparseExample, b *SparseExample) int {
+	if len(b.tokens) < len(a.tokens) {
+		a, b = b, a
+	}
+	n := 0
+	for token := range a.tokens {
+		if b.tokens[token] {
+			n++
+		}
+	}
+	return n
+}
+
+// JaccardSimilarity returns the number of tokens a and b have in
+// common divided by the number of tokens in either. It is 0.0 if
+// neither has any tokens.
+func JaccardSimilarity(a *SparseExample, b *SparseExample) float64 {
+	n := intersection(a, b)
+	return ratio(n, len(a.tokens)+len(b.tokens)-n)
+}
+
+// CosineSimilarity returns the cosine of the angle between a and b as
+// binary vectors of tokens. It is 0.0 if either has no tokens.
+func CosineSimilarity(a *SparseExample, b *SparseExample) float64 {
+	if len(a.tokens) == 0 || len(b.tokens) == 0 {
+		return 0.0
+	}
+	return float64(intersection(a, b)) / math.Sqrt(float64(len(a.tokens))*float64(len(b.tokens)))
+}
+
+// IDF returns the inverse document frequency, log(N/n), of each token
+// in docs, where N is the number of documents and n is the number
+// which contain the token.
+func IDF(docs [][]string) map[string]float64 {
+	counts := make(map[string]int)
+	for _, doc := range docs {
+		seen := make(map[string]bool, len(doc))
+		for _, token := range doc {
+			if !seen[token] {
+				seen[token] = true
+				counts[token]++
+			}
+		}
+	}
+	idf := make(map[string]float64, len(counts))
+	for token, n := range counts {
+		idf[token] = math.Log(float64(len(docs)) / float64(n))
+	}
+	return idf
+}
+
+// TFIDF returns the weight of each token in doc: the fraction of its
+// tokens which are that token, scaled by the token's inverse document
+// frequency. Tokens missing from idf are omitted. Combined with
+// ThresholdFeatures this gives real-valued text features.
+func TFIDF(doc []string, idf map[string]float64) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, token := range doc {
+		if w, ok := idf[token]; ok {
+			weights[token] += w / float64(len(doc))
+		}
+	}
+	return weights
+}
+
+// ReadExamplesCSV reads a SparseExample from each row of CSV from r.
+// The text in column textCol is split into tokens by tokenize, or by
+// Tokenize if it is nil, and column labelCol holds the label in any
+// form strconv.ParseBool accepts. If header is set the first row is
+// skipped. It is an error for a column to be negative or for rows to
+// have different numbers of fields.
+func ReadExamplesCSV(r io.Reader, textCol int, labelCol int, header bool, tokenize func(string) []string) ([]Example, error) {
+	if textCol < 0 || labelCol < 0 {
+		return nil, fmt.Errorf("columns must not be negative but were %d and %d", textCol, labelCol)
+	}
+	if tokenize == nil {
+		tokenize = Tokenize
+	}
+	reader := csv.NewReader(r)
+	var examples []Example
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return examples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header && row == 1 {
+			continue
+		}
+		if textCol >= len(record) || labelCol >= len(record) {
+			return nil, fmt.Errorf("row %d has %d fields", row, len(record))
+		}
+		label, err := strconv.ParseBool(strings.TrimSpace(record[labelCol]))
+		if err != nil {
+			return nil, fmt.Errorf("row %d has label %q", row, record[labelCol])
+		}
+		examples = append(examples, NewSparseExample(tokenize(record[textCol]), Label(label)))
+	}
+}
@@ -0,0 +1,104 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// shuffled returns a copy of examples in a random order.
+func shuffled(examples []Example, r *rand.Rand) []Example {
+	xs := make([]Example, len(examples))
+	for i, j := range r.Perm(len(examples)) {
+		xs[i] = examples[j]
+	}
+	return xs
+}
+
+// LabelStats returns the number of examples in each class. Both
+// classes are present, with a count of zero if there are no examples
+// of it.
+func LabelStats(examples []Example) map[Label]int {
+	counts := map[Label]int{true: 0, false: 0}
+	for _, example := range examples {
+		counts[example.Label()]++
+	}
+	return counts
+}
+
+// BootstrapSample draws len(examples) examples uniformly with
+// replacement. The examples slice is not modified.
+func BootstrapSample(examples []Example, r *rand.Rand) []Example {
+	xs := make([]Example, len(examples))
+	for i := range xs {
+		xs[i] = examples[r.Intn(len(examples))]
+	}
+	return xs
+}
+
+// WeightedBootstrapSample is like BootstrapSample, but draws each
+// example with probability given by d, using an AliasSampler. It is
+// an error for d to be a different length to examples or, as for
+// NewAliasSampler, to sum to zero.
+func WeightedBootstrapSample(examples []Example, d *Distribution, r *rand.Rand) ([]Example, error) {
+	if len(d.P) != len(examples) {
+		return nil, fmt.Errorf("distribution has %d items for %d examples", len(d.P), len(examples))
+	}
+	if len(examples) == 0 {
+		return nil, nil
+	}
+	sampler, err := d.NewAliasSampler()
+	if err != nil {
+		return nil, err
+	}
+	xs := make([]Example, len(examples))
+	for i := range xs {
+		xs[i] = examples[sampler.Sample(r)]
+	}
+	return xs, nil
+}
+
+// TrainTestSplit shuffles examples with r and splits them into a
+// training set and a test set with testFraction of the examples. The
+// examples slice is not modified.
+func TrainTestSplit(examples []Example, testFraction float64, r *rand.Rand) (train, test []Example) {
+	xs := shuffled(examples, r)
+	ntest := int(math.Floor(testFraction*float64(len(xs)) + 0.5))
+	if ntest < 0 {
+		ntest = 0
+	} else if ntest > len(xs) {
+		ntest = len(xs)
+	}
+	return xs[ntest:], xs[:ntest]
+}
+
+// CrossValidate estimates the error rate of boosting learner by k-fold
+// cross validation. Examples are shuffled with r and split into k
+// folds; for each fold a model is trained for rounds rounds, each
+// sampling nexamples examples, on the other folds and evaluated on
+// that fold. It returns the error rate on each fold. It is an error
+// for there to be fewer than 2 folds or more folds than examples,
+// since a fold with no examples would have no error.
+func CrossValidate(examples []Example, learner Learner, k int, rounds int, nexamples int, r *rand.Rand) ([]float64, error) {
+	if k < 2 || k > len(examples) {
+		return nil, fmt.Errorf("cannot cross validate %d examples with %d folds", len(examples), k)
+	}
+	xs := shuffled(examples, r)
+	rates := make([]float64, k)
+	for fold := 0; fold < k; fold++ {
+		var train, test []Example
+		for i, x := range xs {
+			if i%k == fold {
+				test = append(test, x)
+			} else {
+				train = append(train, x)
+			}
+		}
+		booster := NewAdaBoost(train, learner, r)
+		if err := booster.Train(rounds, nexamples); err != nil {
+			return nil, err
+		}
+		rates[fold] = booster.ConfusionMatrix(test).ErrorRate()
+	}
+	return rates, nil
+}